@@ -0,0 +1,118 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log"
+	"mime"
+	"net/http"
+	"time"
+)
+
+// maxConfigBytes limits the size of a JSON config.
+const maxConfigBytes = 1 << 20
+
+// apiHandler serves version 1 of the JSON API. POST /api/v1/mosaics
+// takes an ImageConfig as JSON, generates the mosaic and responds with a
+// mosaicEnvelope describing it.
+type apiHandler struct {
+	generator *MosaicGenerator
+}
+
+// apiError is the body of every failed API request. Fields lists the
+// invalid fields of a rejected config.
+type apiError struct {
+	Error  string       `json:"error"`
+	Fields []fieldError `json:"fields,omitempty"`
+}
+
+// mosaicEnvelope describes a generated mosaic, with the config actually
+// used to make it and links to the stored result, which expire after
+// RESULT_TTL.
+type mosaicEnvelope struct {
+	ID      string      `json:"id"`
+	Width   int         `json:"width"`
+	Height  int         `json:"height"`
+	Config  ImageConfig `json:"config"`
+	Image   string      `json:"image"`
+	Zoom    string      `json:"zoom"`
+	Expires time.Time   `json:"expires"`
+}
+
+func (a *apiHandler) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+	if req.URL.Path != "/api/v1/mosaics" {
+		writeJSON(rw, http.StatusNotFound, apiError{Error: "no such endpoint"})
+		return
+	}
+	if req.Method != "POST" {
+		rw.Header().Set("Allow", "POST")
+		writeJSON(rw, http.StatusMethodNotAllowed, apiError{Error: "mosaics are created with POST"})
+		return
+	}
+	if mediaType, _, _ := mime.ParseMediaType(req.Header.Get("Content-Type")); mediaType != "application/json" {
+		writeJSON(rw, http.StatusUnsupportedMediaType, apiError{Error: "the body must be application/json"})
+		return
+	}
+
+	var config ImageConfig
+	decoder := json.NewDecoder(http.MaxBytesReader(rw, req.Body, maxConfigBytes))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&config); err != nil {
+		writeJSON(rw, http.StatusBadRequest, apiError{Error: "invalid JSON: " + err.Error()})
+		return
+	}
+
+	ctx, cancel := a.generator.withTimeout(req.Context())
+	defer cancel()
+	img, config, err := a.generator.generate(ctx, config, nil, discard{})
+	if err != nil {
+		a.fail(ctx, rw, req, err)
+		return
+	}
+
+	var buf bytes.Buffer
+	err = encodeImage(&buf, img, config.Format)
+	if err != nil {
+		writeJSON(rw, http.StatusInternalServerError, apiError{Error: err.Error()})
+		return
+	}
+	id, err := a.generator.Results.put(img, config.Format, buf.Bytes())
+	if err != nil {
+		writeJSON(rw, http.StatusInternalServerError, apiError{Error: err.Error()})
+		return
+	}
+
+	size := img.Bounds().Size()
+	envelope := mosaicEnvelope{
+		ID:      id,
+		Width:   size.X,
+		Height:  size.Y,
+		Config:  config,
+		Image:   "/zoom/" + id + "/" + zoomName + "." + config.Format,
+		Zoom:    "/zoom/" + id + "/",
+		Expires: time.Now().Add(a.generator.Results.ttl),
+	}
+	rw.Header().Set("Location", envelope.Image)
+	writeJSON(rw, http.StatusCreated, envelope)
+}
+
+// fail reports err, from generating a mosaic within ctx, as an apiError
+// with the status given by errorStatus.
+func (a *apiHandler) fail(ctx context.Context, rw http.ResponseWriter, req *http.Request, err error) {
+	switch {
+	case ctx.Err() == context.DeadlineExceeded:
+		writeJSON(rw, http.StatusServiceUnavailable, apiError{Error: errTimeout.Error()})
+	case ctx.Err() == context.Canceled:
+		log.Println("Request cancelled:", req.URL)
+	default:
+		code := errorStatus(err)
+		body := apiError{Error: err.Error()}
+		if fields, ok := err.(configError); ok {
+			body = apiError{Error: "invalid configuration", Fields: fields}
+		} else {
+			log.Println("Generating mosaic:", err)
+		}
+		writeJSON(rw, code, body)
+	}
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"encoding/json"
+	"image"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Logiraptor/mosaic/mosaic"
+)
+
+func TestAPIErrorStatus(t *testing.T) {
+	input := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		png.Encode(rw, image.NewGray(image.Rect(0, 0, 40, 40)))
+	}))
+	defer input.Close()
+
+	root := t.TempDir()
+	if err := os.Mkdir(filepath.Join(root, "empty"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(root, "broken.tar"), []byte("not a tar archive"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	handler := &apiHandler{generator: &MosaicGenerator{
+		ImageLoader: mosaic.WebImageLoader{},
+		Tiles:       NewTileCache(mosaic.WebImageLoader{}, nil),
+		Results:     newResultStore(time.Hour, 1<<20),
+
+		LocalTileRoot: root,
+	}}
+	tests := []struct {
+		path  string
+		code  int
+		field string
+	}{
+		{"missing", http.StatusBadRequest, "tileSourcePath"},
+		{"empty", http.StatusBadGateway, ""},
+		{"broken.tar", http.StatusInternalServerError, ""},
+	}
+	for _, test := range tests {
+		body := `{"inputImageURL": "` + input.URL + `/in.png", "tileSource": "local", "tileSourcePath": "` + test.path + `"}`
+		req := httptest.NewRequest("POST", "/api/v1/mosaics", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		rw := httptest.NewRecorder()
+		handler.ServeHTTP(rw, req)
+
+		if rw.Code != test.code {
+			t.Errorf("%s: got status %d, want %d: %s", test.path, rw.Code, test.code, rw.Body)
+			continue
+		}
+		var apiErr apiError
+		if err := json.NewDecoder(rw.Body).Decode(&apiErr); err != nil {
+			t.Fatalf("%s: %v", test.path, err)
+		}
+		if test.field != "" && (len(apiErr.Fields) != 1 || apiErr.Fields[0].Field != test.field) {
+			t.Errorf("%s: got fields %v, want %s", test.path, apiErr.Fields, test.field)
+		}
+	}
+}
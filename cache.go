@@ -1,42 +1,114 @@
 package main
 
 import (
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
 	"fmt"
+	"image"
+	"image/png"
 	"log"
 	"net/http"
 	"time"
 
+	"github.com/Logiraptor/mosaic/mosaic"
 	"gopkg.in/go-redis/cache.v3/lrucache"
+	"gopkg.in/redis.v3"
+)
 
-	"image"
+const (
+	memoryCacheSize = 1000
+	cacheKeyPrefix  = "mosaic:image:"
 )
 
 type redisCache struct {
-	codec    *lrucache.Cache
-	fallback ImageLoader
+	client   *redis.Client
+	ttl      time.Duration
+	memory   *lrucache.Cache
+	fallback mosaic.ImageLoader
 }
 
-func NewRedisCache(fallback ImageLoader) *redisCache {
+// NewRedisCache caches images loaded by fallback in Redis as PNG bytes.
+// A nil client, or any error talking to Redis, falls back to an
+// in-process LRU.
+func NewRedisCache(client *redis.Client, ttl time.Duration, fallback mosaic.ImageLoader) *redisCache {
 	return &redisCache{
-		codec:    lrucache.New(time.Hour, 1000),
+		client:   client,
+		ttl:      ttl,
+		memory:   lrucache.New(ttl, memoryCacheSize),
 		fallback: fallback,
 	}
 }
 
-func (r *redisCache) LoadImage(url string) (image.Image, error) {
-	img, ok := r.codec.Get(url)
+func (r *redisCache) LoadImage(ctx context.Context, url string) (image.Image, error) {
+	key := cacheKey(url)
+	if img, ok := r.get(key); ok {
+		return img, nil
+	}
+
+	log.Println("Cache miss:", url)
+	img, err := r.fallback.LoadImage(ctx, url)
+	if err != nil {
+		return nil, err
+	}
+	r.set(key, img)
+	return img, nil
+}
+
+func (r *redisCache) get(key string) (image.Image, bool) {
+	if r.client != nil {
+		data, err := r.client.Get(key).Bytes()
+		switch err {
+		case nil:
+			img, err := png.Decode(bytes.NewReader(data))
+			if err == nil {
+				return img, true
+			}
+			log.Println("Corrupt cache entry:", key, err)
+			return nil, false
+		case redis.Nil:
+			return nil, false
+		default:
+			log.Println("Redis unavailable:", err)
+		}
+	}
+
+	img, ok := r.memory.Get(key)
 	if !ok {
-		log.Println("Cache miss:", url)
-		img, err := r.fallback.LoadImage(url)
-		if err != nil {
-			return nil, err
+		return nil, false
+	}
+	return img.(image.Image), true
+}
+
+func (r *redisCache) set(key string, img image.Image) {
+	if r.client != nil {
+		var buf bytes.Buffer
+		err := png.Encode(&buf, img)
+		if err == nil {
+			err = r.client.Set(key, buf.Bytes(), r.ttl).Err()
 		}
-		r.codec.Set(url, img)
-		return img, nil
+		if err == nil {
+			return
+		}
+		log.Println("Redis unavailable:", err)
 	}
-	return img.(image.Image), nil
+	r.memory.Set(key, img)
+}
+
+func cacheKey(url string) string {
+	sum := sha1.Sum([]byte(url))
+	return cacheKeyPrefix + hex.EncodeToString(sum[:])
 }
 
 func (r *redisCache) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
-	fmt.Fprintf(rw, "%d/%d Cached", r.codec.Len(), 1000)
+	if r.client != nil {
+		n, err := r.client.DbSize().Result()
+		if err != nil {
+			fmt.Fprintf(rw, "Redis unavailable: %v\n", err)
+		} else {
+			fmt.Fprintf(rw, "%d keys in Redis\n", n)
+		}
+	}
+	fmt.Fprintf(rw, "%d/%d Cached", r.memory.Len(), memoryCacheSize)
 }
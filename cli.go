@@ -0,0 +1,219 @@
+package main
+
+import (
+	"archive/zip"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"image"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/Logiraptor/mosaic/mosaic"
+)
+
+const generateUsage = `usage: mosaic generate -in <path or URL> [flags]
+
+Builds a mosaic of the input image and writes it to -out.
+
+With -pyramid, -out names a deep zoom pyramid instead: name.dzi and its
+name_files directory for dzi, or a name directory for xyz. An -out
+ending in .zip writes the same files to a zip archive.
+
+`
+
+// generateCommand implements `mosaic generate`, which renders a mosaic to
+// disk without starting the web server.
+func generateCommand(args []string) error {
+	var (
+		config ImageConfig
+		flags  = flag.NewFlagSet("generate", flag.ExitOnError)
+		in     = flags.String("in", "", "input image path or URL")
+		out    = flags.String("out", "mosaic.png", "output path, .png or .jpg")
+
+		pyramid       = flags.String("pyramid", "", "write a tile pyramid, dzi or xyz, instead of one image")
+		pyramidFormat = flags.String("pyramid-format", "png", "format of pyramid tiles: png or jpg")
+	)
+	flags.Usage = func() {
+		fmt.Fprint(os.Stderr, generateUsage)
+		flags.PrintDefaults()
+	}
+	flags.StringVar(&config.TileSource, "source", defaultTileSource, "tile source: imgur or local")
+	flags.StringVar(&config.TileSourceSubreddit, "subreddit", "aww", "subreddit for the imgur source")
+	flags.StringVar(&config.TileSourcePath, "tiles", "", "directory or archive for the local source")
+	flags.IntVar(&config.NumSamples, "samples", 100, "number of tile images to use")
+	flags.IntVar(&config.TileSize, "tile-size", 25, "width of each tile in the output, in pixels")
+	flags.IntVar(&config.SampleSize, "sample-size", 0, "width of the input region each tile covers; 0 picks one automatically")
+	flags.IntVar(&config.Columns, "columns", 0, "number of tiles across, instead of -sample-size")
+	flags.IntVar(&config.Rows, "rows", 0, "number of tiles down, instead of -sample-size")
+	flags.StringVar(&config.Metric, "metric", "ycbcr", "color metric: ycbcr, rgb, cie76 or ciede2000")
+	flags.IntVar(&config.Grid, "grid", 1, "rows and columns of colors compared per tile")
+	flags.IntVar(&config.MaxUses, "max-uses", 0, "maximum number of cells each tile may fill; 0 for no limit")
+	flags.IntVar(&config.MinRepeatDistance, "min-repeat-distance", 0, "minimum distance in cells between uses of the same tile")
+	flags.Int64Var(&config.Seed, "seed", 0, "seed for the order cells are filled in when tile use is limited")
+	flags.StringVar(&config.Assignment, "assignment", "greedy", "tile assignment: greedy or optimal")
+	flags.StringVar(&config.Dither, "dither", "none", "error diffusion: none, floyd-steinberg, jarvis or stucki")
+	flags.Float64Var(&config.Tint, "tint", 0, "strength of color correction toward each cell, from 0 to 1")
+	flags.StringVar(&config.TintMode, "tint-mode", "blend", "color correction: blend or hue")
+	flags.Float64Var(&config.Overlay, "overlay", 0, "opacity of the input image blended over the mosaic, from 0 to 1")
+	flags.StringVar(&config.OverlayMode, "overlay-mode", "normal", "overlay blend: normal, multiply or soft-light")
+	flags.BoolVar(&config.Rotations, "rotations", false, "also use each tile rotated by 90, 180 and 270 degrees")
+	flags.BoolVar(&config.Mirrors, "mirrors", false, "also use each tile's horizontal and vertical mirror images")
+	flags.StringVar(&config.Layout, "layout", "grid", "tile layout: grid, quadtree, brick, hex or circles")
+	flags.IntVar(&config.MinTileSize, "min-tile-size", 0, "smallest tile in the quadtree layout; 0 for a quarter of -tile-size")
+	flags.Float64Var(&config.DetailThreshold, "detail", 0, "color deviation above which quadtree tiles are split; 0 for the default")
+	flags.StringVar(&config.Background, "background", "#000000", "color of the grout and border, as a hex triplet")
+	flags.IntVar(&config.Grout, "grout", 0, "width of the gaps between tiles, in pixels")
+	flags.IntVar(&config.Border, "border", 0, "width of the border around the mosaic, in pixels")
+	flags.IntVar(&config.CornerRadius, "corner-radius", 0, "radius of rounded tile corners, in pixels")
+	flags.Parse(args)
+
+	if *in == "" {
+		flags.Usage()
+		return errors.New("no input image given")
+	}
+	generator := &MosaicGenerator{
+		ImageLoader: mosaic.WebImageLoader{},
+		Tiles:       NewTileCache(mosaic.WebImageLoader{}, newTileStore()),
+	}
+	if config.TileSourcePath != "" {
+		// -tiles may be any path, so resolve it against its own directory.
+		path, err := filepath.Abs(config.TileSourcePath)
+		if err != nil {
+			return err
+		}
+		generator.LocalTileRoot, config.TileSourcePath = filepath.Split(path)
+	}
+
+	config, err := config.normalize()
+	if err != nil {
+		return err
+	}
+	img, err := loadInput(*in)
+	if err != nil {
+		return err
+	}
+
+	progress := &stderrReporter{}
+	result, _, err := generator.process(context.Background(), config, img, progress)
+	progress.end()
+	if err != nil {
+		return err
+	}
+	if *pyramid != "" {
+		scheme, err := mosaic.ParsePyramidScheme(*pyramid)
+		if err != nil {
+			return err
+		}
+		return writePyramid(*out, result, mosaic.PyramidOptions{Scheme: scheme, Format: *pyramidFormat})
+	}
+	return writeImage(*out, result)
+}
+
+// stderrReporter prints the progress of each stage but the paging of
+// tile sources on a line of standard error.
+type stderrReporter struct {
+	stage   string
+	printed time.Time
+}
+
+func (r *stderrReporter) setState(jobState) {}
+
+func (r *stderrReporter) progress(p mosaic.Progress) {
+	if p.Stage == "pages" {
+		return
+	}
+	if r.stage != p.Stage {
+		r.end()
+	} else if p.Done+p.Failed < p.Total && time.Since(r.printed) < 100*time.Millisecond {
+		return
+	}
+	r.stage = p.Stage
+	r.printed = time.Now()
+	switch {
+	case p.Failed > 0:
+		fmt.Fprintf(os.Stderr, "\r%s: %d/%d (%d failed)", p.Stage, p.Done, p.Total, p.Failed)
+	case p.Total > 0:
+		fmt.Fprintf(os.Stderr, "\r%s: %d/%d", p.Stage, p.Done, p.Total)
+	default:
+		fmt.Fprintf(os.Stderr, "\r%s: %d", p.Stage, p.Done)
+	}
+}
+
+// end finishes the current line, if any.
+func (r *stderrReporter) end() {
+	if r.stage != "" {
+		fmt.Fprintln(os.Stderr)
+	}
+	r.stage = ""
+}
+
+func loadInput(location string) (image.Image, error) {
+	if strings.HasPrefix(location, "http://") || strings.HasPrefix(location, "https://") {
+		return mosaic.WebImageLoader{}.LoadImage(context.Background(), location)
+	}
+
+	f, err := os.Open(location)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	img, _, err := image.Decode(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", location, err)
+	}
+	return img, nil
+}
+
+func writeImage(path string, img image.Image) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+
+	format := "png"
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".jpg", ".jpeg":
+		format = "jpg"
+	}
+	err = encodeImage(f, img, format)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+func writePyramid(path string, img image.Image, opts mosaic.PyramidOptions) error {
+	pyramid, err := mosaic.NewPyramid(img, opts)
+	if err != nil {
+		return err
+	}
+	name := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+
+	if strings.ToLower(filepath.Ext(path)) != ".zip" {
+		dir := mosaic.NewDirWriter(filepath.Dir(path))
+		err = pyramid.Write(dir, name)
+		if closeErr := dir.Close(); err == nil {
+			err = closeErr
+		}
+		return err
+	}
+
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	archive := zip.NewWriter(f)
+	err = pyramid.Write(archive, name)
+	if closeErr := archive.Close(); err == nil {
+		err = closeErr
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
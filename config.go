@@ -0,0 +1,256 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"net/url"
+	"strings"
+
+	"github.com/Logiraptor/mosaic/mosaic"
+)
+
+// Limits on ImageConfig, chosen to keep a single mosaic within the memory
+// and time of one server.
+const (
+	defaultTileSize   = 25
+	defaultNumSamples = 100
+	maxNumSamples     = 2000
+	maxTileSize       = 256
+	maxSampleSize     = 1024
+	maxGrid           = 5
+	maxSpacing        = 256
+	// maxRepeatDistance bounds MinRepeatDistance, as each cell checks
+	// the (2d-1)² cells around it.
+	maxRepeatDistance = 10
+	// maxCells bounds the number of columns and rows of tiles.
+	maxCells = 1000
+	// maxPixels bounds the size of the rendered mosaic.
+	maxPixels = 250 << 20
+	// targetCells is the number of tiles along the longest side of the
+	// input when neither SampleSize, Columns nor Rows is given.
+	targetCells = 150
+)
+
+// errNoInput is returned for configs which name no input image when none
+// was uploaded.
+var errNoInput = configError{{Field: "inputImageURL", Message: "is required unless an image is uploaded"}}
+
+// A fieldError explains why one field of an ImageConfig is invalid.
+// Field is the field's JSON name.
+type fieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// configError lists every invalid field of an ImageConfig.
+type configError []fieldError
+
+func (e configError) Error() string {
+	msgs := make([]string, len(e))
+	for i, f := range e {
+		msgs[i] = f.Field + " " + f.Message
+	}
+	return "invalid configuration: " + strings.Join(msgs, "; ")
+}
+
+func (e *configError) add(field, format string, args ...interface{}) {
+	*e = append(*e, fieldError{Field: field, Message: fmt.Sprintf(format, args...)})
+}
+
+// between checks that value lies in [lo, hi].
+func (e *configError) between(field string, value, lo, hi int) {
+	if value < lo || value > hi {
+		e.add(field, "must be between %d and %d", lo, hi)
+	}
+}
+
+// normalize fills in defaults for c's zero fields, canonicalises its
+// names and checks every field, returning a configError listing all
+// that are out of range. It does not check that an input is given.
+func (c ImageConfig) normalize() (ImageConfig, error) {
+	var errs configError
+
+	if c.TileSize == 0 {
+		c.TileSize = defaultTileSize
+	}
+	if c.NumSamples == 0 {
+		c.NumSamples = defaultNumSamples
+	}
+	if c.Grid == 0 {
+		c.Grid = 1
+	}
+	errs.between("tileSize", c.TileSize, 4, maxTileSize)
+	errs.between("numSamples", c.NumSamples, 1, maxNumSamples)
+	errs.between("sampleSize", c.SampleSize, 0, maxSampleSize)
+	errs.between("columns", c.Columns, 0, maxCells)
+	errs.between("rows", c.Rows, 0, maxCells)
+	if c.SampleSize != 0 && (c.Columns != 0 || c.Rows != 0) {
+		errs.add("sampleSize", "cannot be given with columns or rows")
+	}
+	errs.between("grid", c.Grid, 1, maxGrid)
+	if c.Grid > c.TileSize {
+		errs.add("grid", "must not exceed tileSize")
+	}
+
+	if c.InputImageURL != "" {
+		u, err := url.Parse(c.InputImageURL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			errs.add("inputImageURL", "must be an http or https URL")
+		}
+	}
+	if c.TileSource == "" {
+		c.TileSource = defaultTileSource
+	}
+	if _, ok := tileSources[c.TileSource]; !ok {
+		errs.add("tileSource", "must be one of imgur, local")
+	}
+	if c.TileSource == "imgur" && c.TileSourceSubreddit == "" {
+		errs.add("tileSourceSubreddit", "is required for the imgur source")
+	}
+	if c.TileSource == "local" && c.TileSourcePath == "" {
+		errs.add("tileSourcePath", "is required for the local source")
+	}
+
+	if v, err := mosaic.ParseMetric(c.Metric); err != nil {
+		errs.add("metric", "must be one of ycbcr, rgb, cie76, ciede2000")
+	} else {
+		c.Metric = v.String()
+	}
+	if v, err := mosaic.ParseAssignment(c.Assignment); err != nil {
+		errs.add("assignment", "must be one of greedy, optimal")
+	} else {
+		c.Assignment = v.String()
+	}
+	if v, err := mosaic.ParseDither(c.Dither); err != nil {
+		errs.add("dither", "must be one of none, floyd-steinberg, jarvis, stucki")
+	} else {
+		c.Dither = v.String()
+	}
+	if v, err := mosaic.ParseTintMode(c.TintMode); err != nil {
+		errs.add("tintMode", "must be one of blend, hue")
+	} else {
+		c.TintMode = v.String()
+	}
+	if v, err := mosaic.ParseBlendMode(c.OverlayMode); err != nil {
+		errs.add("overlayMode", "must be one of normal, multiply, soft-light")
+	} else {
+		c.OverlayMode = v.String()
+	}
+	if v, err := mosaic.ParseLayout(c.Layout); err != nil {
+		errs.add("layout", "must be one of grid, quadtree, brick, hex, circles")
+	} else {
+		c.Layout = v.String()
+	}
+
+	if c.MaxUses < 0 {
+		errs.add("maxUses", "must not be negative")
+	}
+	errs.between("minRepeatDistance", c.MinRepeatDistance, 0, maxRepeatDistance)
+	// Reject options which the chosen layout or assignment would
+	// otherwise silently ignore.
+	switch {
+	case c.Layout == mosaic.LayoutQuadtree.String():
+		const unsupported = "is not supported by the quadtree layout"
+		if c.Assignment != mosaic.Greedy.String() {
+			errs.add("assignment", "must be greedy with the quadtree layout")
+		}
+		if c.Dither != mosaic.NoDither.String() {
+			errs.add("dither", unsupported)
+		}
+		if c.MaxUses > 0 {
+			errs.add("maxUses", unsupported)
+		}
+		if c.MinRepeatDistance > 1 {
+			errs.add("minRepeatDistance", unsupported)
+		}
+	case c.Assignment == mosaic.Optimal.String():
+		const unsupported = "is not supported by optimal assignment"
+		if c.Dither != mosaic.NoDither.String() {
+			errs.add("dither", unsupported)
+		}
+		if c.MinRepeatDistance > 1 {
+			errs.add("minRepeatDistance", unsupported)
+		}
+	}
+	if c.Tint < 0 || c.Tint > 1 {
+		errs.add("tint", "must be between 0 and 1")
+	}
+	if c.Overlay < 0 || c.Overlay > 1 {
+		errs.add("overlay", "must be between 0 and 1")
+	}
+	errs.between("minTileSize", c.MinTileSize, 0, c.TileSize)
+	if c.DetailThreshold < 0 {
+		errs.add("detailThreshold", "must not be negative")
+	}
+	if c.Background == "" {
+		c.Background = "#000000"
+	}
+	if _, err := mosaic.ParseColor(c.Background); err != nil {
+		errs.add("background", "must be a color such as #336699")
+	}
+	errs.between("grout", c.Grout, 0, maxSpacing)
+	errs.between("border", c.Border, 0, maxSpacing)
+	errs.between("cornerRadius", c.CornerRadius, 0, c.TileSize)
+
+	switch c.Output {
+	case "":
+		c.Output = "image"
+	case "image", "zoom":
+	default:
+		errs.add("output", "must be one of image, zoom")
+	}
+	switch c.Format {
+	case "":
+		c.Format = "png"
+	case "png", "jpg":
+	default:
+		errs.add("format", "must be one of png, jpg")
+	}
+
+	if len(errs) > 0 {
+		return c, errs
+	}
+	return c, nil
+}
+
+// resolve picks the SampleSize for an input with the given bounds, from
+// SampleSize, Columns or Rows if given, and sets Columns and Rows to the
+// size of the resulting grid of tiles. MinRepeatDistance is capped at the
+// grid's longest side, beyond which it has no further effect. c must
+// already be normalized.
+func (c ImageConfig) resolve(bounds image.Rectangle) (ImageConfig, error) {
+	size := bounds.Size()
+	switch {
+	case c.SampleSize > 0:
+	case c.Columns > 0 && c.Rows > 0:
+		c.SampleSize = max(size.X/c.Columns, size.Y/c.Rows)
+	case c.Columns > 0:
+		c.SampleSize = size.X / c.Columns
+	case c.Rows > 0:
+		c.SampleSize = size.Y / c.Rows
+	default:
+		c.SampleSize = max(size.X, size.Y) / targetCells
+	}
+	c.SampleSize = max(c.SampleSize, 1)
+
+	c.Columns, c.Rows = size.X/c.SampleSize, size.Y/c.SampleSize
+	c.MinRepeatDistance = min(c.MinRepeatDistance, max(c.Columns, c.Rows))
+	var errs configError
+	switch {
+	case c.Columns == 0 || c.Rows == 0:
+		errs.add("sampleSize", "must not exceed the %dx%d input", size.X, size.Y)
+	case c.Columns > maxCells || c.Rows > maxCells:
+		errs.add("sampleSize", "gives a %dx%d grid of tiles, more than %d on a side", c.Columns, c.Rows, maxCells)
+	default:
+		pitch := int64(c.TileSize + c.Grout)
+		width := int64(c.Columns)*pitch + int64(2*c.Border)
+		height := int64(c.Rows)*pitch + int64(2*c.Border)
+		if width*height > maxPixels {
+			errs.add("tileSize", "gives a %dx%d mosaic, more than %d megapixels", width, height, maxPixels>>20)
+		}
+	}
+	if len(errs) > 0 {
+		return c, errs
+	}
+	return c, nil
+}
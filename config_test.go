@@ -0,0 +1,71 @@
+package main
+
+import (
+	"image"
+	"strings"
+	"testing"
+)
+
+func TestNormalizeLimitsMinRepeatDistance(t *testing.T) {
+	_, err := ImageConfig{TileSource: "imgur", TileSourceSubreddit: "pics", MinRepeatDistance: maxRepeatDistance + 1}.normalize()
+	if fields, ok := err.(configError); !ok || len(fields) != 1 || fields[0].Field != "minRepeatDistance" {
+		t.Errorf("got error %v, want one for minRepeatDistance", err)
+	}
+}
+
+func TestResolveCapsMinRepeatDistance(t *testing.T) {
+	c, err := ImageConfig{TileSource: "imgur", TileSourceSubreddit: "pics", Columns: 8, MinRepeatDistance: maxRepeatDistance}.normalize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err = c.resolve(image.Rect(0, 0, 80, 40))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Columns != 8 || c.Rows != 4 {
+		t.Fatalf("resolved a %dx%d grid, want 8x4", c.Columns, c.Rows)
+	}
+	if c.MinRepeatDistance != 8 {
+		t.Errorf("minRepeatDistance resolved to %d, want 8", c.MinRepeatDistance)
+	}
+}
+
+func TestNormalizeRejectsIgnoredOptions(t *testing.T) {
+	base := ImageConfig{TileSource: "imgur", TileSourceSubreddit: "pics"}
+	tests := []struct {
+		change func(*ImageConfig)
+		fields []string
+	}{
+		{func(c *ImageConfig) { c.Layout = "quadtree" }, nil},
+		{func(c *ImageConfig) {
+			c.Layout = "quadtree"
+			c.Assignment = "optimal"
+			c.Dither = "jarvis"
+			c.MaxUses = 2
+			c.MinRepeatDistance = 3
+		}, []string{"assignment", "dither", "maxUses", "minRepeatDistance"}},
+		{func(c *ImageConfig) { c.Assignment = "optimal"; c.MaxUses = 2 }, nil},
+		{func(c *ImageConfig) {
+			c.Assignment = "optimal"
+			c.Dither = "stucki"
+			c.MinRepeatDistance = 2
+		}, []string{"dither", "minRepeatDistance"}},
+		{func(c *ImageConfig) { c.Dither = "stucki"; c.MinRepeatDistance = 2; c.MaxUses = 1 }, nil},
+	}
+	for i, test := range tests {
+		c := base
+		test.change(&c)
+		_, err := c.normalize()
+		var got []string
+		if fields, ok := err.(configError); ok {
+			for _, f := range fields {
+				got = append(got, f.Field)
+			}
+		} else if err != nil {
+			t.Fatalf("%d: %v", i, err)
+		}
+		if strings.Join(got, ",") != strings.Join(test.fields, ",") {
+			t.Errorf("%d: rejected %v, want %v", i, got, test.fields)
+		}
+	}
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// diskStore is a content-addressed blob store on the local filesystem.
+// Entries are evicted least recently used first once the store grows
+// past maxBytes, and are dropped once they are older than maxAge.
+type diskStore struct {
+	dir      string
+	maxBytes int64
+	maxAge   time.Duration
+
+	mu   sync.Mutex
+	size int64
+}
+
+func newDiskStore(dir string, maxBytes int64, maxAge time.Duration) (*diskStore, error) {
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return nil, err
+	}
+	d := &diskStore{
+		dir:      dir,
+		maxBytes: maxBytes,
+		maxAge:   maxAge,
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d, d.evict()
+}
+
+func (d *diskStore) Get(key string) ([]byte, bool) {
+	path := d.path(key)
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, false
+	}
+	if d.expired(info, time.Now()) {
+		d.remove(path, info.Size())
+		return nil, false
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, false
+	}
+	// The modification time doubles as the last access time for eviction.
+	now := time.Now()
+	os.Chtimes(path, now, now)
+	return data, true
+}
+
+func (d *diskStore) Put(key string, data []byte) error {
+	path := d.path(key)
+	err := os.MkdirAll(filepath.Dir(path), 0755)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(path), ".tmp-")
+	if err != nil {
+		return err
+	}
+	_, err = tmp.Write(data)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	var previous int64
+	if info, err := os.Stat(path); err == nil {
+		previous = info.Size()
+	}
+	err = os.Rename(tmp.Name(), path)
+	if err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	d.size += int64(len(data)) - previous
+	if d.size > d.maxBytes {
+		return d.evict()
+	}
+	return nil
+}
+
+func (d *diskStore) path(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	name := hex.EncodeToString(sum[:])
+	return filepath.Join(d.dir, name[:2], name)
+}
+
+func (d *diskStore) expired(info os.FileInfo, now time.Time) bool {
+	return d.maxAge > 0 && now.Sub(info.ModTime()) > d.maxAge
+}
+
+func (d *diskStore) remove(path string, size int64) {
+	if os.Remove(path) != nil {
+		return
+	}
+	d.mu.Lock()
+	d.size -= size
+	d.mu.Unlock()
+}
+
+// isHex reports whether s is n lowercase hex digits.
+func isHex(s string, n int) bool {
+	if len(s) != n {
+		return false
+	}
+	for _, c := range s {
+		if !('0' <= c && c <= '9' || 'a' <= c && c <= 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+type storeEntry struct {
+	path string
+	info os.FileInfo
+}
+
+// evict rescans the store's entries, dropping expired ones and then the
+// least recently used until it fits in 90% of maxBytes, so that a full
+// store is not rescanned on every Put. d.mu must be held.
+func (d *diskStore) evict() error {
+	var (
+		entries []storeEntry
+		size    int64
+		now     = time.Now()
+	)
+	err := filepath.Walk(d.dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		// Only the store's own entries are touched, in case dir holds
+		// anything else.
+		rel, err := filepath.Rel(d.dir, path)
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			if rel != "." && !isHex(rel, 2) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		dir, name := filepath.Split(rel)
+		if !info.Mode().IsRegular() || !isHex(name, sha256.Size*2) || filepath.Clean(dir) != name[:2] {
+			return nil
+		}
+		if d.expired(info, now) {
+			return os.Remove(path)
+		}
+		entries = append(entries, storeEntry{path: path, info: info})
+		size += info.Size()
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].info.ModTime().Before(entries[j].info.ModTime())
+	})
+	target := d.maxBytes
+	if size > d.maxBytes {
+		target = d.maxBytes / 10 * 9
+	}
+	for _, e := range entries {
+		if size <= target {
+			break
+		}
+		if err := os.Remove(e.path); err != nil {
+			log.Println("Tile cache eviction:", err)
+			continue
+		}
+		size -= e.info.Size()
+	}
+	d.size = size
+	return nil
+}
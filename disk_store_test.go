@@ -0,0 +1,223 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/color"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func newTestDiskStore(t *testing.T, maxBytes int64, maxAge time.Duration) *diskStore {
+	d, err := newDiskStore(t.TempDir(), maxBytes, maxAge)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return d
+}
+
+// age sets the last access time of key to ago before now.
+func age(t *testing.T, d *diskStore, key string, ago time.Duration) {
+	when := time.Now().Add(-ago)
+	if err := os.Chtimes(d.path(key), when, when); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDiskStorePutGet(t *testing.T) {
+	d := newTestDiskStore(t, 1<<20, 0)
+	if _, ok := d.Get("a"); ok {
+		t.Fatal("empty store returned an entry")
+	}
+	for _, data := range []string{"first", "second, longer"} {
+		if err := d.Put("a", []byte(data)); err != nil {
+			t.Fatal(err)
+		}
+		got, ok := d.Get("a")
+		if !ok || string(got) != data {
+			t.Errorf("got %q, %v, want %q", got, ok, data)
+		}
+	}
+	if d.size != int64(len("second, longer")) {
+		t.Errorf("store counts %d bytes after an overwrite, want %d", d.size, len("second, longer"))
+	}
+}
+
+func TestDiskStoreEvictsLeastRecentlyUsed(t *testing.T) {
+	d := newTestDiskStore(t, 300, 0)
+	data := make([]byte, 100)
+	for _, key := range []string{"a", "b", "c"} {
+		if err := d.Put(key, data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	age(t, d, "a", 3*time.Hour)
+	age(t, d, "b", 2*time.Hour)
+	age(t, d, "c", time.Hour)
+	if _, ok := d.Get("a"); !ok {
+		t.Fatal("a was not stored")
+	}
+
+	// Going over maxBytes evicts down to 90% of it: b and then c, which
+	// were used longest ago.
+	if err := d.Put("d", data); err != nil {
+		t.Fatal(err)
+	}
+	for key, want := range map[string]bool{"a": true, "b": false, "c": false, "d": true} {
+		if _, ok := d.Get(key); ok != want {
+			t.Errorf("%s kept: %v, want %v", key, ok, want)
+		}
+	}
+	if d.size != 200 {
+		t.Errorf("store counts %d bytes, want 200", d.size)
+	}
+}
+
+func TestDiskStoreExpires(t *testing.T) {
+	d := newTestDiskStore(t, 1<<20, time.Hour)
+	for _, key := range []string{"old", "new"} {
+		if err := d.Put(key, []byte(key)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	age(t, d, "old", 2*time.Hour)
+	if _, ok := d.Get("old"); ok {
+		t.Error("got an entry older than maxAge")
+	}
+	if _, err := os.Stat(d.path("old")); !os.IsNotExist(err) {
+		t.Errorf("expired entry left on disk: %v", err)
+	}
+	if _, ok := d.Get("new"); !ok {
+		t.Error("fresh entry was dropped")
+	}
+
+	// Reopening the store drops expired entries left from before.
+	age(t, d, "new", 2*time.Hour)
+	reopened, err := newDiskStore(d.dir, 1<<20, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reopened.size != 0 {
+		t.Errorf("reopened store counts %d bytes, want 0", reopened.size)
+	}
+}
+
+// countingLoader serves flat images of one color, counting the loads.
+type countingLoader struct {
+	mu    sync.Mutex
+	loads int
+}
+
+func (l *countingLoader) LoadImage(ctx context.Context, url string) (image.Image, error) {
+	l.mu.Lock()
+	l.loads++
+	l.mu.Unlock()
+	img := image.NewRGBA(image.Rect(0, 0, 8, 8))
+	for i := range img.Pix {
+		img.Pix[i] = 200
+	}
+	return img, nil
+}
+
+func TestTileCacheLoadOrder(t *testing.T) {
+	const (
+		url  = "http://example.com/tile.png"
+		size = 4
+	)
+	var (
+		ctx      = context.Background()
+		disk     = newTestDiskStore(t, 1<<20, 0)
+		fallback = new(countingLoader)
+		want     = color.RGBA{200, 200, 200, 200}
+	)
+	load := func(cache *tileCache, loads int) {
+		t.Helper()
+		img, err := cache.load(ctx, url, size)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if img.Bounds() != image.Rect(0, 0, size, size) {
+			t.Errorf("loaded a tile of %v, want %dx%d", img.Bounds(), size, size)
+		}
+		if got := color.RGBAModel.Convert(img.At(1, 1)); got != want {
+			t.Errorf("loaded a tile of %v, want %v", got, want)
+		}
+		if fallback.loads != loads {
+			t.Errorf("fallback loaded %d times, want %d", fallback.loads, loads)
+		}
+	}
+
+	// A miss loads from the fallback and fills both layers.
+	cache := NewTileCache(fallback, disk)
+	load(cache, 1)
+	if _, ok := disk.Get("4:" + url); !ok {
+		t.Fatal("tile was not written to disk")
+	}
+
+	// Memory is checked first, so removing the disk copy changes nothing.
+	if err := os.Remove(disk.path("4:" + url)); err != nil {
+		t.Fatal(err)
+	}
+	load(cache, 1)
+
+	// Without a memory copy, the disk copy is used.
+	load(NewTileCache(fallback, disk), 2)
+	load(NewTileCache(fallback, disk), 2)
+
+	// A corrupt disk copy falls back to loading, and is replaced.
+	if err := disk.Put("4:"+url, []byte("not a png")); err != nil {
+		t.Fatal(err)
+	}
+	load(NewTileCache(fallback, disk), 3)
+	data, _ := disk.Get("4:" + url)
+	if bytes.Equal(data, []byte("not a png")) {
+		t.Error("corrupt entry was not replaced")
+	}
+}
+
+func TestDiskStoreLeavesOtherFiles(t *testing.T) {
+	dir := t.TempDir()
+	others := []string{
+		"notes.txt",
+		"ab/notes.txt",
+		"sub/" + strings.Repeat("a", 64),
+		"ab/" + strings.Repeat("c", 64),
+	}
+	for _, name := range others {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, make([]byte, 100), 0644); err != nil {
+			t.Fatal(err)
+		}
+		old := time.Now().Add(-48 * time.Hour)
+		if err := os.Chtimes(path, old, old); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	d, err := newDiskStore(dir, 150, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Put("a", make([]byte, 100)); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Put("b", make([]byte, 100)); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range others {
+		if _, err := os.Stat(filepath.Join(dir, name)); err != nil {
+			t.Errorf("%s was removed: %v", name, err)
+		}
+	}
+	if d.size != 100 {
+		t.Errorf("store counts %d bytes, want 100", d.size)
+	}
+}
@@ -0,0 +1,391 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Logiraptor/mosaic/mosaic"
+)
+
+type jobState string
+
+const (
+	jobQueued      jobState = "queued"
+	jobDownloading jobState = "downloading"
+	jobMatching    jobState = "matching"
+	jobRendering   jobState = "rendering"
+	jobDone        jobState = "done"
+	jobFailed      jobState = "failed"
+)
+
+var errQueueFull = errors.New("too many jobs are waiting; try again later")
+
+// eventInterval is how often /jobs/{id}/events checks for progress.
+const eventInterval = 250 * time.Millisecond
+
+// A job generates a mosaic in the background.
+type job struct {
+	mu     sync.Mutex
+	id     string
+	config ImageConfig
+	// upload is the encoded input image, if one was uploaded, which is
+	// only decoded once the job starts.
+	upload   []byte
+	state    jobState
+	err      error
+	pages    int
+	tiles    mosaic.Progress
+	cells    mosaic.Progress
+	encoded  int64
+	size     image.Point
+	resultID string
+	finished time.Time
+}
+
+// jobStatus is the JSON form of a job.
+type jobStatus struct {
+	ID             string   `json:"id"`
+	State          jobState `json:"state"`
+	Error          string   `json:"error,omitempty"`
+	PagesLoaded    int      `json:"pagesLoaded"`
+	TilesRequested int      `json:"tilesRequested"`
+	TilesLoaded    int      `json:"tilesLoaded"`
+	TilesFailed    int      `json:"tilesFailed"`
+	Cells          int      `json:"cells"`
+	CellsMatched   int      `json:"cellsMatched"`
+	BytesEncoded   int64    `json:"bytesEncoded"`
+	Width          int      `json:"width,omitempty"`
+	Height         int      `json:"height,omitempty"`
+	Result         string   `json:"result,omitempty"`
+	Zoom           string   `json:"zoom,omitempty"`
+}
+
+func (j *job) setState(state jobState) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.state = state
+}
+
+func (j *job) progress(p mosaic.Progress) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	switch p.Stage {
+	case "pages":
+		j.pages = p.Done
+	case "tiles":
+		j.tiles = p
+	case "cells":
+		j.cells = p
+	}
+}
+
+// Write counts bytes of the encoded result, which it discards.
+func (j *job) Write(b []byte) (int, error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.encoded += int64(len(b))
+	return len(b), nil
+}
+
+func (j *job) finish(resultID string, size image.Point, err error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.state = jobDone
+	if err != nil {
+		j.state = jobFailed
+	}
+	j.resultID = resultID
+	j.size = size
+	j.err = err
+	j.finished = time.Now()
+	j.upload = nil
+}
+
+func (j *job) status() jobStatus {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	s := jobStatus{
+		ID:             j.id,
+		State:          j.state,
+		PagesLoaded:    j.pages,
+		TilesRequested: j.config.NumSamples,
+		TilesLoaded:    j.tiles.Done,
+		TilesFailed:    j.tiles.Failed,
+		Cells:          j.cells.Total,
+		CellsMatched:   j.cells.Done,
+		BytesEncoded:   j.encoded,
+		Width:          j.size.X,
+		Height:         j.size.Y,
+	}
+	if j.err != nil {
+		s.Error = j.err.Error()
+	}
+	if j.state == jobDone {
+		s.Result = "/jobs/" + j.id + "/result"
+		s.Zoom = "/zoom/" + j.resultID + "/"
+	}
+	return s
+}
+
+// jobQueue runs jobs on a fixed number of workers. Finished jobs are
+// forgotten after the result store's TTL; their results may be evicted
+// sooner if the store is full.
+type jobQueue struct {
+	generator *MosaicGenerator
+	pending   chan *job
+
+	mu   sync.Mutex
+	jobs map[string]*job
+}
+
+// newJobQueue starts workers which run jobs submitted to the returned
+// queue, of which at most capacity may wait at once.
+func newJobQueue(generator *MosaicGenerator, workers, capacity int) *jobQueue {
+	q := &jobQueue{
+		generator: generator,
+		pending:   make(chan *job, capacity),
+		jobs:      make(map[string]*job),
+	}
+	for i := 0; i < workers; i++ {
+		go q.work()
+	}
+	return q
+}
+
+// jobQueueSize reads JOB_WORKERS and JOB_QUEUE_SIZE, which default to 2
+// and 32.
+func jobQueueSize() (workers, capacity int) {
+	workers, err := strconv.Atoi(os.Getenv("JOB_WORKERS"))
+	if err != nil || workers <= 0 {
+		workers = 2
+	}
+	capacity, err = strconv.Atoi(os.Getenv("JOB_QUEUE_SIZE"))
+	if err != nil || capacity < 0 {
+		capacity = 32
+	}
+	return workers, capacity
+}
+
+// submit queues a job to render config, from upload if not nil.
+func (q *jobQueue) submit(config ImageConfig, upload []byte) (*job, error) {
+	id, err := newID()
+	if err != nil {
+		return nil, err
+	}
+	j := &job{id: id, config: config, upload: upload, state: jobQueued}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	select {
+	case q.pending <- j:
+	default:
+		return nil, errQueueFull
+	}
+	q.expire()
+	q.jobs[id] = j
+	return j, nil
+}
+
+func (q *jobQueue) get(id string) (*job, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.expire()
+	j, ok := q.jobs[id]
+	return j, ok
+}
+
+// expire drops finished jobs older than the result TTL. q.mu must be
+// held.
+func (q *jobQueue) expire() {
+	cutoff := time.Now().Add(-q.generator.Results.ttl)
+	for id, j := range q.jobs {
+		j.mu.Lock()
+		finished := j.finished
+		j.mu.Unlock()
+		if !finished.IsZero() && finished.Before(cutoff) {
+			delete(q.jobs, id)
+		}
+	}
+}
+
+func (q *jobQueue) work() {
+	for j := range q.pending {
+		q.run(j)
+	}
+}
+
+func (q *jobQueue) run(j *job) {
+	defer func() {
+		if r := recover(); r != nil {
+			j.finish("", image.Point{}, fmt.Errorf("panic: %v", r))
+		}
+	}()
+
+	ctx, cancel := q.generator.withTimeout(context.Background())
+	defer cancel()
+	img, _, err := q.generator.generate(ctx, j.config, j.upload, j)
+	if ctx.Err() == context.DeadlineExceeded {
+		err = errTimeout
+	}
+	if err != nil {
+		j.finish("", image.Point{}, err)
+		return
+	}
+
+	j.setState(jobRendering)
+	var buf bytes.Buffer
+	err = encodeImage(io.MultiWriter(&buf, j), img, j.config.Format)
+	if err != nil {
+		j.finish("", image.Point{}, err)
+		return
+	}
+	id, err := q.generator.Results.put(img, j.config.Format, buf.Bytes())
+	j.finish(id, img.Bounds().Size(), err)
+}
+
+// ServeHTTP handles POST /jobs, which queues a job configured like
+// /generate, GET /jobs/{id}, which reports its status,
+// GET /jobs/{id}/events, which streams its status as server-sent events,
+// and GET /jobs/{id}/result, which returns the finished image.
+func (q *jobQueue) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+	path := strings.Trim(strings.TrimPrefix(req.URL.Path, "/jobs"), "/")
+	if path == "" {
+		if req.Method != "POST" {
+			rw.Header().Set("Allow", "POST")
+			http.Error(rw, "jobs are created with POST", http.StatusMethodNotAllowed)
+			return
+		}
+		q.create(rw, req)
+		return
+	}
+
+	parts := strings.SplitN(path, "/", 2)
+	j, ok := q.get(parts[0])
+	if !ok {
+		http.NotFound(rw, req)
+		return
+	}
+	switch {
+	case len(parts) == 1:
+		writeJSON(rw, http.StatusOK, j.status())
+	case parts[1] == "events":
+		q.events(rw, req, j)
+	case parts[1] == "result":
+		q.result(rw, req, j)
+	default:
+		http.NotFound(rw, req)
+	}
+}
+
+func (q *jobQueue) create(rw http.ResponseWriter, req *http.Request) {
+	config, upload, err := q.generator.readRequest(rw, req)
+	if err != nil {
+		http.Error(rw, err.Error(), requestStatus(err))
+		return
+	}
+	config, err = config.normalize()
+	if err == nil && upload == nil && config.InputImageURL == "" {
+		err = errNoInput
+	}
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	j, err := q.submit(config, upload)
+	if err == errQueueFull {
+		http.Error(rw, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	rw.Header().Set("Location", "/jobs/"+j.id)
+	writeJSON(rw, http.StatusAccepted, j.status())
+}
+
+// events sends a progress event whenever j's status changes, then a done
+// or failed event once it finishes.
+func (q *jobQueue) events(rw http.ResponseWriter, req *http.Request, j *job) {
+	flusher, ok := rw.(http.Flusher)
+	if !ok {
+		http.Error(rw, "streaming is not supported", http.StatusInternalServerError)
+		return
+	}
+	rw.Header().Set("Content-Type", "text/event-stream")
+	rw.Header().Set("Cache-Control", "no-cache")
+
+	ticker := time.NewTicker(eventInterval)
+	defer ticker.Stop()
+	var last *jobStatus
+	for {
+		status := j.status()
+		if last == nil || status != *last {
+			event := "progress"
+			if status.State == jobDone || status.State == jobFailed {
+				event = string(status.State)
+			}
+			data, err := json.Marshal(status)
+			if err != nil {
+				log.Println("Writing event:", err)
+				return
+			}
+			_, err = fmt.Fprintf(rw, "event: %s\ndata: %s\n\n", event, data)
+			if err != nil {
+				return
+			}
+			flusher.Flush()
+			if event != "progress" {
+				return
+			}
+			last = &status
+		}
+
+		select {
+		case <-ticker.C:
+		case <-req.Context().Done():
+			return
+		}
+	}
+}
+
+func (q *jobQueue) result(rw http.ResponseWriter, req *http.Request, j *job) {
+	status := j.status()
+	if status.State != jobDone {
+		http.Error(rw, "job is "+string(status.State), http.StatusConflict)
+		return
+	}
+	j.mu.Lock()
+	id := j.resultID
+	j.mu.Unlock()
+	res, ok := q.generator.Results.get(id)
+	if !ok {
+		http.Error(rw, "result has expired or been evicted", http.StatusGone)
+		return
+	}
+	rw.Header().Set("Content-Type", contentType(res.format))
+	rw.Header().Set("Content-Length", strconv.Itoa(len(res.encoded)))
+	rw.Write(res.encoded)
+}
+
+func writeJSON(rw http.ResponseWriter, code int, v interface{}) {
+	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(code)
+	err := json.NewEncoder(rw).Encode(v)
+	if err != nil {
+		log.Println("Writing JSON:", err)
+	}
+}
@@ -1,18 +1,24 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"html/template"
 	"image"
-	"image/color"
-	_ "image/jpeg"
-	"image/png"
+	"image/jpeg"
+	_ "image/png"
+	"io"
 	"log"
 	"net/http"
 	"os"
+	"path/filepath"
+	"strconv"
 	"strings"
+	"time"
 
-	"github.com/gorilla/schema"
+	"github.com/Logiraptor/mosaic/mosaic"
+	"gopkg.in/redis.v3"
 
 	_ "expvar"
 
@@ -21,10 +27,6 @@ import (
 
 var tmpls *template.Template
 
-func init() {
-	tmpls = template.Must(template.ParseGlob("template/*"))
-}
-
 type Credentials struct {
 	Host     string
 	Password string
@@ -45,17 +47,131 @@ func readVcap(vcapServices string) (VCapServices, error) {
 	return vcap, err
 }
 
+// redisOptions reads Redis credentials from a bound p-redis service,
+// falling back to REDIS_HOST, REDIS_PORT and REDIS_PASSWORD.
+func redisOptions() (*redis.Options, bool) {
+	var creds Credentials
+	if env := os.Getenv("VCAP_SERVICES"); env != "" {
+		vcap, err := readVcap(env)
+		if err != nil {
+			log.Println("Invalid VCAP_SERVICES:", err)
+		} else if len(vcap.Redis) > 0 {
+			creds = vcap.Redis[0].Credentials
+		}
+	}
+	if creds.Host == "" {
+		creds.Host = os.Getenv("REDIS_HOST")
+		creds.Password = os.Getenv("REDIS_PASSWORD")
+		creds.Port, _ = strconv.Atoi(os.Getenv("REDIS_PORT"))
+	}
+	if creds.Host == "" {
+		return nil, false
+	}
+	if creds.Port == 0 {
+		creds.Port = 6379
+	}
+	return &redis.Options{
+		Addr:         fmt.Sprintf("%s:%d", creds.Host, creds.Port),
+		Password:     creds.Password,
+		DialTimeout:  time.Second,
+		ReadTimeout:  time.Second,
+		WriteTimeout: time.Second,
+	}, true
+}
+
+func newRedisClient() *redis.Client {
+	opts, ok := redisOptions()
+	if !ok {
+		log.Println("No Redis configured, caching images in memory")
+		return nil
+	}
+	client := redis.NewClient(opts)
+	if err := client.Ping().Err(); err != nil {
+		log.Println("Redis unreachable, caching images in memory:", err)
+		client.Close()
+		return nil
+	}
+	return client
+}
+
+// newTileStore opens the on-disk thumbnail store configured by
+// TILE_CACHE_DIR, TILE_CACHE_BYTES and TILE_CACHE_MAX_AGE.
+func newTileStore() *diskStore {
+	dir := os.Getenv("TILE_CACHE_DIR")
+	if dir == "" {
+		dir = filepath.Join(os.TempDir(), "mosaic-tiles")
+	}
+	maxBytes, err := strconv.ParseInt(os.Getenv("TILE_CACHE_BYTES"), 10, 64)
+	if err != nil || maxBytes <= 0 {
+		maxBytes = 256 << 20
+	}
+	maxAge, err := time.ParseDuration(os.Getenv("TILE_CACHE_MAX_AGE"))
+	if err != nil {
+		maxAge = 7 * 24 * time.Hour
+	}
+
+	store, err := newDiskStore(dir, maxBytes, maxAge)
+	if err != nil {
+		log.Println("Tile cache disabled:", err)
+		return nil
+	}
+	return store
+}
+
+func cacheTTL() time.Duration {
+	ttl, err := time.ParseDuration(os.Getenv("CACHE_TTL"))
+	if err != nil || ttl <= 0 {
+		return 24 * time.Hour
+	}
+	return ttl
+}
+
+// generateTimeout reads GENERATE_TIMEOUT, the longest a mosaic may take
+// to generate, which defaults to five minutes.
+func generateTimeout() time.Duration {
+	timeout, err := time.ParseDuration(os.Getenv("GENERATE_TIMEOUT"))
+	if err != nil || timeout <= 0 {
+		return 5 * time.Minute
+	}
+	return timeout
+}
+
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "generate" {
+		err := generateCommand(os.Args[2:])
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	tmpls = template.Must(template.ParseGlob("template/*"))
+
 	port := os.Getenv("PORT")
 	if port == "" {
 		port = "3000"
 	}
 
-	cache := NewRedisCache(webImageLoader{})
-
-	http.Handle("/generate", &MosaicGenerator{
+	cache := NewRedisCache(newRedisClient(), cacheTTL(), mosaic.WebImageLoader{})
+	results := newResultStore(resultTTL(), resultMaxBytes())
+	generator := &MosaicGenerator{
 		ImageLoader: cache,
-	})
+		Tiles:       NewTileCache(cache, newTileStore()),
+		Results:     results,
+		Timeout:     generateTimeout(),
+
+		LocalTileRoot: os.Getenv("LOCAL_TILE_ROOT"),
+	}
+	generator.MaxUploadBytes, generator.MaxInputPixels = uploadLimits()
+	workers, capacity := jobQueueSize()
+	jobs := newJobQueue(generator, workers, capacity)
+
+	http.Handle("/generate", generator)
+	http.Handle("/api/v1/", &apiHandler{generator: generator})
+	http.Handle("/jobs", jobs)
+	http.Handle("/jobs/", jobs)
+	http.Handle("/zoom/", &zoomHandler{results: results, viewer: openSeadragonBase()})
 	http.Handle("/cached", cache)
 	http.Handle("/static/", http.StripPrefix("/static", http.FileServer(http.Dir("public"))))
 	http.HandleFunc("/", indexHandler)
@@ -68,15 +184,85 @@ func indexHandler(rw http.ResponseWriter, req *http.Request) {
 	})
 }
 
+// ImageConfig describes a mosaic. It is decoded from form values by
+// /generate and /jobs and from JSON by /api/v1/mosaics; zero values
+// select the defaults filled in by normalize.
 type ImageConfig struct {
-	SampleSize           int
-	NumSamples, TileSize int
-	TileSourceSubreddit  string
-	InputImageURL        string
+	// SampleSize is the width of the input region each tile covers.
+	// Columns or Rows may be given instead, and SampleSize is then
+	// chosen to give that many tiles across or down.
+	SampleSize          int     `json:"sampleSize"`
+	Columns             int     `json:"columns"`
+	Rows                int     `json:"rows"`
+	NumSamples          int     `json:"numSamples"`
+	TileSize            int     `json:"tileSize"`
+	TileSource          string  `json:"tileSource"`
+	TileSourceSubreddit string  `json:"tileSourceSubreddit,omitempty"`
+	TileSourcePath      string  `json:"tileSourcePath,omitempty"`
+	InputImageURL       string  `json:"inputImageURL"`
+	Metric              string  `json:"metric"`
+	Grid                int     `json:"grid"`
+	MaxUses             int     `json:"maxUses"`
+	MinRepeatDistance   int     `json:"minRepeatDistance"`
+	Seed                int64   `json:"seed"`
+	Assignment          string  `json:"assignment"`
+	Dither              string  `json:"dither"`
+	Tint                float64 `json:"tint"`
+	TintMode            string  `json:"tintMode"`
+	Overlay             float64 `json:"overlay"`
+	OverlayMode         string  `json:"overlayMode"`
+	Rotations           bool    `json:"rotations"`
+	Mirrors             bool    `json:"mirrors"`
+	Layout              string  `json:"layout"`
+	MinTileSize         int     `json:"minTileSize"`
+	DetailThreshold     float64 `json:"detailThreshold"`
+	Background          string  `json:"background"`
+	Grout               int     `json:"grout"`
+	Border              int     `json:"border"`
+	CornerRadius        int     `json:"cornerRadius"`
+	// Output is "image" to return the mosaic or "zoom" to open it in the
+	// deep zoom viewer, and Format is the image format, "png" or "jpg".
+	Output string `json:"output"`
+	Format string `json:"format"`
 }
 
 type MosaicGenerator struct {
-	ImageLoader
+	mosaic.ImageLoader
+	Tiles   *tileCache
+	Results *resultStore
+	// Timeout bounds each generation. Zero means no limit.
+	Timeout time.Duration
+	// MaxUploadBytes and MaxInputPixels limit uploaded input images.
+	// Zero means no limit.
+	MaxUploadBytes int64
+	MaxInputPixels int
+	// LocalTileRoot is the directory that TileSourcePath is resolved
+	// against. The local source is disabled while it is empty.
+	LocalTileRoot string
+}
+
+var errTimeout = errors.New("the mosaic took too long to generate")
+
+// errorStatus returns the status with which to report an error from
+// generate. Only invalid configs are the client's fault; when no tiles
+// could be loaded, the tile source is to blame.
+func errorStatus(err error) int {
+	if _, ok := err.(configError); ok {
+		return http.StatusBadRequest
+	}
+	if err == mosaic.ErrNoTiles {
+		return http.StatusBadGateway
+	}
+	return http.StatusInternalServerError
+}
+
+// withTimeout returns a context which is done when ctx is or m.Timeout
+// has passed.
+func (m *MosaicGenerator) withTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if m.Timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, m.Timeout)
 }
 
 func (m *MosaicGenerator) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
@@ -85,38 +271,61 @@ func (m *MosaicGenerator) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
 			fmt.Fprintf(rw, "panic: %v", r)
 		}
 	}()
-	var config ImageConfig
-	req.ParseForm()
-	err := schema.NewDecoder().Decode(&config, req.Form)
+	config, upload, err := m.readRequest(rw, req)
 	if err != nil {
-		http.Error(rw, err.Error(), http.StatusBadRequest)
+		http.Error(rw, err.Error(), requestStatus(err))
 		return
 	}
 
-	img, err := m.LoadImage(config.InputImageURL)
-	if err != nil {
-		http.Error(rw, err.Error(), http.StatusBadRequest)
+	ctx, cancel := m.withTimeout(req.Context())
+	defer cancel()
+	after, config, err := m.generate(ctx, config, upload, discard{})
+	switch {
+	case ctx.Err() == context.DeadlineExceeded:
+		http.Error(rw, errTimeout.Error(), http.StatusServiceUnavailable)
+		return
+	case ctx.Err() == context.Canceled:
+		log.Println("Request cancelled:", req.URL)
+		return
+	case err != nil:
+		code := errorStatus(err)
+		if code != http.StatusBadRequest {
+			log.Println("Generating mosaic:", err)
+		}
+		http.Error(rw, err.Error(), code)
 		return
 	}
 
-	config.TileSize = 25
-
-	idealTileWidth := float32(config.TileSize * 150)
-	maxDim := float32(max(img.Bounds().Dx(), img.Bounds().Dy()))
-
-	config.SampleSize = int((maxDim / idealTileWidth) * float32(config.TileSize))
-
-	after, err := m.process(config, img)
-	if err != nil {
-		http.Error(rw, err.Error(), http.StatusBadRequest)
+	if config.Output == "zoom" {
+		id, err := m.Results.put(after, config.Format, nil)
+		if err != nil {
+			http.Error(rw, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		http.Redirect(rw, req, "/zoom/"+id+"/", http.StatusSeeOther)
 		return
 	}
 
-	err = png.Encode(rw, after)
+	rw.Header().Set("Content-Type", contentType(config.Format))
+	err = encodeImage(rw, after, config.Format)
 	if err != nil {
-		http.Error(rw, err.Error(), http.StatusBadRequest)
-		return
+		log.Println("Writing mosaic:", err)
+	}
+}
+
+// encodeImage writes img to w in format, "png" or "jpg".
+func encodeImage(w io.Writer, img image.Image, format string) error {
+	if format == "jpg" {
+		return jpeg.Encode(w, img, &jpeg.Options{Quality: 90})
 	}
+	return mosaic.EncodePNG(w, img)
+}
+
+func contentType(format string) string {
+	if format == "jpg" {
+		return "image/jpeg"
+	}
+	return "image/png"
 }
 
 func max(x, y int) int {
@@ -126,64 +335,157 @@ func max(x, y int) int {
 	return y
 }
 
-type SubImage struct {
-	rect image.Rectangle
-	image.Image
+func min(x, y int) int {
+	if x < y {
+		return x
+	}
+	return y
 }
 
-func (s SubImage) Bounds() image.Rectangle {
-	return s.rect
+// A reporter is told how far the generation of a mosaic has got.
+type reporter interface {
+	setState(jobState)
+	progress(mosaic.Progress)
 }
 
-func (m *MosaicGenerator) process(c ImageConfig, in image.Image) (image.Image, error) {
-	images, err := DownloadImages(m.ImageLoader, c.TileSourceSubreddit, c.NumSamples, c.TileSize)
+// discard is a reporter which ignores everything.
+type discard struct{}
+
+func (discard) setState(jobState)        {}
+func (discard) progress(mosaic.Progress) {}
+
+// generate renders the mosaic of an uploaded input, or if that is nil
+// the image named by c, reporting progress to r, until ctx is done. It returns the
+// config actually used, with defaults filled in and the grid of tiles
+// resolved. Invalid configs and inputs which cannot be loaded give a
+// configError.
+func (m *MosaicGenerator) generate(ctx context.Context, c ImageConfig, upload []byte, r reporter) (image.Image, ImageConfig, error) {
+	c, err := c.normalize()
 	if err != nil {
-		return nil, err
+		return nil, c, err
+	}
+	if upload != nil {
+		c.InputImageURL = ""
+		input, err := decodeInput(upload)
+		if err != nil {
+			return nil, c, err
+		}
+		return m.process(ctx, c, input, r)
+	}
+	if c.InputImageURL == "" {
+		return nil, c, errNoInput
 	}
 
-	tiler, err := NewTiler(images)
+	r.setState(jobDownloading)
+	input, err := m.LoadImage(ctx, c.InputImageURL)
 	if err != nil {
-		return nil, err
+		if ctx.Err() == nil {
+			err = configError{{Field: "inputImageURL", Message: "could not be loaded: " + err.Error()}}
+		}
+		return nil, c, err
 	}
-	return c.mosaic(tiler.match, in), nil
+	return m.process(ctx, c, input, r)
 }
 
-func (c *ImageConfig) mosaic(strategy func(color.Color) image.Image, in image.Image) image.Image {
-
-	in = cropToMultiple(in, c.SampleSize)
-	bounds := in.Bounds().Canon()
-
-	numTilesX := bounds.Dx() / c.SampleSize
-	numTilesY := bounds.Dy() / c.SampleSize
-
-	in = resize(in, image.Rect(0, 0, numTilesX, numTilesY))
-
-	output := NewMosaic(numTilesX, numTilesY, c.TileSize)
+// process renders the mosaic of in described by c, which must be
+// normalized, and returns it with the config actually used.
+func (m *MosaicGenerator) process(ctx context.Context, c ImageConfig, in image.Image, r reporter) (image.Image, ImageConfig, error) {
+	c, err := c.resolve(in.Bounds())
+	if err != nil {
+		return nil, c, err
+	}
 
-	parallelMap(numTilesX, func(i int) {
-		parallelMap(numTilesY, func(j int) {
-			result := strategy(in.At(i, j))
-			output.images[i][j] = result
-		})
-	})
+	r.setState(jobDownloading)
+	source, err := newTileSource(c, m.LocalTileRoot, m.Tiles.Thumbnails(c.TileSize))
+	if err != nil {
+		return nil, c, err
+	}
+	if closer, ok := source.(io.Closer); ok {
+		defer closer.Close()
+	}
 
-	return output
-}
+	images, err := mosaic.LoadTiles(ctx, source, c.NumSamples, c.TileSize, r.progress)
+	if err != nil {
+		return nil, c, err
+	}
+	r.setState(jobMatching)
 
-func cropToMultiple(img image.Image, tileSize int) image.Image {
-	min := img.Bounds().Min
-	width := roundTo(img.Bounds().Dx(), tileSize)
-	height := roundTo(img.Bounds().Dy(), tileSize)
-	return crop(img, image.Rect(min.X, min.Y, width+min.X, height+min.Y))
+	tilerOpts, err := c.tilerOptions()
+	if err != nil {
+		return nil, c, err
+	}
+	tiler, err := mosaic.NewTiler(images, tilerOpts)
+	if err != nil {
+		return nil, c, err
+	}
+	opts, err := c.options()
+	if err != nil {
+		return nil, c, err
+	}
+	opts.Progress = r.progress
+	out, err := mosaic.Render(ctx, in, tiler, opts)
+	if err != nil {
+		return nil, c, err
+	}
+	return out, c, nil
 }
 
-func roundTo(x, div int) int {
-	return (x / div) * div
+func (c ImageConfig) tilerOptions() (mosaic.TilerOptions, error) {
+	metric, err := mosaic.ParseMetric(c.Metric)
+	if err != nil {
+		return mosaic.TilerOptions{}, err
+	}
+	return mosaic.TilerOptions{
+		Metric:    metric,
+		Grid:      c.Grid,
+		Rotations: c.Rotations,
+		Mirrors:   c.Mirrors,
+	}, nil
 }
 
-func crop(img image.Image, rect image.Rectangle) image.Image {
-	return SubImage{
-		Image: img,
-		rect:  rect,
+func (c ImageConfig) options() (mosaic.Options, error) {
+	assignment, err := mosaic.ParseAssignment(c.Assignment)
+	if err != nil {
+		return mosaic.Options{}, err
+	}
+	dither, err := mosaic.ParseDither(c.Dither)
+	if err != nil {
+		return mosaic.Options{}, err
+	}
+	tintMode, err := mosaic.ParseTintMode(c.TintMode)
+	if err != nil {
+		return mosaic.Options{}, err
+	}
+	overlayMode, err := mosaic.ParseBlendMode(c.OverlayMode)
+	if err != nil {
+		return mosaic.Options{}, err
+	}
+	layout, err := mosaic.ParseLayout(c.Layout)
+	if err != nil {
+		return mosaic.Options{}, err
+	}
+	background, err := mosaic.ParseColor(c.Background)
+	if err != nil {
+		return mosaic.Options{}, err
 	}
+	return mosaic.Options{
+		SampleSize:        c.SampleSize,
+		TileSize:          c.TileSize,
+		MaxUses:           c.MaxUses,
+		MinRepeatDistance: c.MinRepeatDistance,
+		Seed:              c.Seed,
+		Assignment:        assignment,
+		Dither:            dither,
+		Tint:              c.Tint,
+		TintMode:          tintMode,
+		Overlay:           c.Overlay,
+		OverlayMode:       overlayMode,
+		Layout:            layout,
+		MinTileSize:       c.MinTileSize,
+		DetailThreshold:   c.DetailThreshold,
+		Background:        background,
+		Grout:             c.Grout,
+		Border:            c.Border,
+		CornerRadius:      c.CornerRadius,
+	}, nil
 }
@@ -0,0 +1,114 @@
+package mosaic
+
+import (
+	"context"
+	"math/rand"
+)
+
+// cellGrid holds the samples and points describing each cell of the
+// input, in row-major order, and counts cells as they are matched.
+type cellGrid struct {
+	width, height int
+	samples       [][]float64
+	points        [][]float64
+	matched       *progress
+}
+
+func newCellGrid(width, height int) *cellGrid {
+	return &cellGrid{
+		width:   width,
+		height:  height,
+		samples: make([][]float64, width*height),
+		points:  make([][]float64, width*height),
+	}
+}
+
+func (g *cellGrid) index(x, y int) int {
+	return y*g.width + x
+}
+
+// greedyAssign picks the nearest tile for every cell independently.
+func greedyAssign(ctx context.Context, tiler *Tiler, cells *cellGrid) []int {
+	choices := make([]int, len(cells.points))
+	parallelMap(ctx, len(cells.points), func(i int) {
+		choices[i] = tiler.nearest(cells.points[i], nil)
+		cells.matched.add(1, 0)
+	})
+	return choices
+}
+
+// constrainedAssign visits cells one at a time in an order shuffled by
+// opts.Seed, giving each the nearest tile allowed by its constraints.
+func constrainedAssign(ctx context.Context, tiler *Tiler, cells *cellGrid, opts Options) []int {
+	var (
+		choices = newConstraints(tiler, cells, opts)
+		order   = rand.New(rand.NewSource(opts.Seed)).Perm(len(cells.points))
+	)
+	for _, c := range order {
+		if ctx.Err() != nil {
+			break
+		}
+		choices.choose(c, cells.points[c])
+	}
+	return choices.choices
+}
+
+// constraints tracks tile choices as they are made one cell at a time,
+// so that later cells avoid tiles which are over opts.MaxUses or used
+// within opts.MinRepeatDistance. When no tile satisfies both, a cell
+// falls back to its nearest tile. Uses are counted per source image, so
+// variants of a tile share its limits.
+type constraints struct {
+	tiler   *Tiler
+	cells   *cellGrid
+	opts    Options
+	choices []int
+	uses    []int
+	nearby  map[int]bool
+}
+
+func newConstraints(tiler *Tiler, cells *cellGrid, opts Options) *constraints {
+	choices := make([]int, len(cells.points))
+	for i := range choices {
+		choices[i] = -1
+	}
+	return &constraints{
+		tiler:   tiler,
+		cells:   cells,
+		opts:    opts,
+		choices: choices,
+		uses:    make([]int, len(tiler.sources)),
+		nearby:  make(map[int]bool),
+	}
+}
+
+// choose picks and records the tile for cell c, matching point q.
+func (k *constraints) choose(c int, q []float64) int {
+	var (
+		cells  = k.cells
+		x, y   = c % cells.width, c / cells.width
+		radius = k.opts.MinRepeatDistance - 1
+	)
+	for source := range k.nearby {
+		delete(k.nearby, source)
+	}
+	for ny := max(y-radius, 0); ny <= min(y+radius, cells.height-1); ny++ {
+		for nx := max(x-radius, 0); nx <= min(x+radius, cells.width-1); nx++ {
+			if choice := k.choices[cells.index(nx, ny)]; choice >= 0 {
+				k.nearby[k.tiler.images[choice].source] = true
+			}
+		}
+	}
+
+	choice := k.tiler.nearest(q, func(tile int) bool {
+		source := k.tiler.images[tile].source
+		return (k.opts.MaxUses <= 0 || k.uses[source] < k.opts.MaxUses) && !k.nearby[source]
+	})
+	if choice < 0 {
+		choice = k.tiler.nearest(q, nil)
+	}
+	k.choices[c] = choice
+	k.uses[k.tiler.images[choice].source]++
+	cells.matched.add(1, 0)
+	return choice
+}
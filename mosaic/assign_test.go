@@ -0,0 +1,75 @@
+package mosaic
+
+import (
+	"context"
+	"image"
+	"image/color"
+	"testing"
+)
+
+// grayTiler returns a Tiler over n flat gray tiles of increasing level.
+func grayTiler(t *testing.T, n int) *Tiler {
+	images := make([]image.Image, n)
+	for i := range images {
+		img := image.NewGray(image.Rect(0, 0, 4, 4))
+		for j := range img.Pix {
+			img.Pix[j] = uint8(i * 255 / n)
+		}
+		images[i] = img
+	}
+	tiler, err := NewTiler(images, TilerOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tiler
+}
+
+// grayCells returns a width x height grid of cells all matching level.
+func grayCells(tiler *Tiler, width, height int, level uint8) *cellGrid {
+	cells := newCellGrid(width, height)
+	point := tiler.metric.point(color.Gray{Y: level})
+	for i := range cells.points {
+		cells.points[i] = point
+	}
+	return cells
+}
+
+func TestConstrainedAssignSpacesRepeats(t *testing.T) {
+	tiler := grayTiler(t, 20)
+	cells := grayCells(tiler, 12, 9, 128)
+	choices := constrainedAssign(context.Background(), tiler, cells, Options{MinRepeatDistance: 2})
+
+	for y := 0; y < cells.height; y++ {
+		for x := 0; x < cells.width; x++ {
+			c := choices[cells.index(x, y)]
+			for _, n := range [][2]int{{x + 1, y}, {x, y + 1}, {x + 1, y + 1}, {x - 1, y + 1}} {
+				if n[0] < 0 || n[0] >= cells.width || n[1] >= cells.height {
+					continue
+				}
+				if choices[cells.index(n[0], n[1])] == c {
+					t.Fatalf("cells (%d, %d) and %v both use tile %d", x, y, n, c)
+				}
+			}
+		}
+	}
+}
+
+func TestConstrainedAssignHugeRepeatDistance(t *testing.T) {
+	tiler := grayTiler(t, 4)
+	cells := grayCells(tiler, 10, 10, 128)
+	// The neighbourhood is clamped to the grid, so this must not scan
+	// billions of cells per choice.
+	choices := constrainedAssign(context.Background(), tiler, cells, Options{MinRepeatDistance: 1 << 30})
+
+	nearest := tiler.nearest(cells.points[0], nil)
+	used := make(map[int]int)
+	for _, c := range choices {
+		used[c]++
+	}
+	if len(used) != 4 {
+		t.Errorf("used tiles %v, want each of the 4 once and then the nearest", used)
+	}
+	if used[nearest] != len(choices)-3 {
+		t.Errorf("nearest tile used %d times, want %d", used[nearest], len(choices)-3)
+	}
+}
@@ -0,0 +1,117 @@
+package mosaic
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"strconv"
+	"strings"
+)
+
+type RGBAColor struct {
+	r, g, b, a uint32
+}
+
+func (r RGBAColor) RGBA() (uint32, uint32, uint32, uint32) {
+	return r.r, r.g, r.b, r.a
+}
+
+func averageColor(in image.Image) color.Color {
+	bounds := in.Bounds().Canon()
+
+	numPixels := uint64((bounds.Max.X - bounds.Min.X) * (bounds.Max.Y - bounds.Min.Y))
+	var rSum, gSum, bSum, aSum uint64
+
+	for i := bounds.Min.X; i < bounds.Max.X; i++ {
+		for j := bounds.Min.Y; j < bounds.Max.Y; j++ {
+			color := in.At(i, j)
+			r, g, b, a := color.RGBA()
+			rSum += uint64(r)
+			gSum += uint64(g)
+			bSum += uint64(b)
+			aSum += uint64(a)
+		}
+	}
+
+	return RGBAColor{
+		r: uint32(rSum / numPixels),
+		g: uint32(gSum / numPixels),
+		b: uint32(bSum / numPixels),
+		a: uint32(aSum / numPixels),
+	}
+}
+
+// gridAverages divides in into a grid x grid array of regions and returns
+// their average colors in row-major order.
+func gridAverages(in image.Image, grid int) []color.Color {
+	bounds := in.Bounds().Canon()
+	averages := make([]color.Color, 0, grid*grid)
+	for j := 0; j < grid; j++ {
+		for i := 0; i < grid; i++ {
+			region := image.Rect(
+				bounds.Min.X+i*bounds.Dx()/grid,
+				bounds.Min.Y+j*bounds.Dy()/grid,
+				bounds.Min.X+(i+1)*bounds.Dx()/grid,
+				bounds.Min.Y+(j+1)*bounds.Dy()/grid,
+			)
+			// Images narrower than the grid share pixels between regions.
+			if region.Dx() == 0 {
+				region.Max.X++
+			}
+			if region.Dy() == 0 {
+				region.Max.Y++
+			}
+			averages = append(averages, averageColor(crop(in, region)))
+		}
+	}
+	return averages
+}
+
+// sampleColor converts 16-bit RGB components, which may have drifted out
+// of range, to an opaque color.
+func sampleColor(r, g, b float64) color.Color {
+	return color.RGBA64{
+		R: clamp16(r),
+		G: clamp16(g),
+		B: clamp16(b),
+		A: 0xffff,
+	}
+}
+
+func clamp16(v float64) uint16 {
+	switch {
+	case v <= 0:
+		return 0
+	case v >= 0xffff:
+		return 0xffff
+	}
+	return uint16(v + 0.5)
+}
+
+// averageSamples returns the mean of a list of 16-bit RGB samples.
+func averageSamples(samples []float64) color.Color {
+	var (
+		sum [3]float64
+		n   = float64(len(samples) / 3)
+	)
+	for i := 0; i+3 <= len(samples); i += 3 {
+		sum[0] += samples[i]
+		sum[1] += samples[i+1]
+		sum[2] += samples[i+2]
+	}
+	return sampleColor(sum[0]/n, sum[1]/n, sum[2]/n)
+}
+
+// ParseColor parses an opaque color written as a hex triplet such as
+// "#ff8000". The leading "#" is optional and the empty string is black.
+func ParseColor(s string) (color.Color, error) {
+	if s == "" {
+		return color.Black, nil
+	}
+	hex := strings.TrimPrefix(s, "#")
+	v, err := strconv.ParseUint(hex, 16, 32)
+	if err != nil || len(hex) != 6 {
+		return nil, fmt.Errorf("mosaic: invalid color %q", s)
+	}
+	return color.RGBA{R: uint8(v >> 16), G: uint8(v >> 8), B: uint8(v), A: 0xff}, nil
+}
@@ -0,0 +1,132 @@
+package mosaic
+
+import (
+	"context"
+	"fmt"
+)
+
+// A Dither selects an error diffusion kernel.
+type Dither int
+
+// Kernels further down the list spread error over more neighbours, which
+// gives smoother gradients at the cost of some sharpness.
+const (
+	NoDither Dither = iota
+	FloydSteinberg
+	JarvisJudiceNinke
+	Stucki
+)
+
+var ditherNames = map[Dither]string{
+	NoDither:          "none",
+	FloydSteinberg:    "floyd-steinberg",
+	JarvisJudiceNinke: "jarvis",
+	Stucki:            "stucki",
+}
+
+// ParseDither returns the Dither with the given name, as printed by
+// Dither.String. The empty string selects NoDither.
+func ParseDither(name string) (Dither, error) {
+	if name == "" {
+		return NoDither, nil
+	}
+	for d, n := range ditherNames {
+		if n == name {
+			return d, nil
+		}
+	}
+	return 0, fmt.Errorf("mosaic: unknown dither %q", name)
+}
+
+func (d Dither) String() string {
+	if name, ok := ditherNames[d]; ok {
+		return name
+	}
+	return fmt.Sprintf("Dither(%d)", int(d))
+}
+
+// diffusion is one weight of an error diffusion kernel, relative to the
+// cell being matched when traversing left to right.
+type diffusion struct {
+	dx, dy int
+	weight float64
+}
+
+var ditherKernels = map[Dither][]diffusion{
+	FloydSteinberg: {
+		{1, 0, 7.0 / 16},
+		{-1, 1, 3.0 / 16}, {0, 1, 5.0 / 16}, {1, 1, 1.0 / 16},
+	},
+	JarvisJudiceNinke: {
+		{1, 0, 7.0 / 48}, {2, 0, 5.0 / 48},
+		{-2, 1, 3.0 / 48}, {-1, 1, 5.0 / 48}, {0, 1, 7.0 / 48}, {1, 1, 5.0 / 48}, {2, 1, 3.0 / 48},
+		{-2, 2, 1.0 / 48}, {-1, 2, 3.0 / 48}, {0, 2, 5.0 / 48}, {1, 2, 3.0 / 48}, {2, 2, 1.0 / 48},
+	},
+	Stucki: {
+		{1, 0, 8.0 / 42}, {2, 0, 4.0 / 42},
+		{-2, 1, 2.0 / 42}, {-1, 1, 4.0 / 42}, {0, 1, 8.0 / 42}, {1, 1, 4.0 / 42}, {2, 1, 2.0 / 42},
+		{-2, 2, 1.0 / 42}, {-1, 2, 2.0 / 42}, {0, 2, 4.0 / 42}, {1, 2, 2.0 / 42}, {2, 2, 1.0 / 42},
+	},
+}
+
+// ditherAssign matches cells in serpentine raster order, adding the
+// error left by each cell's tile to the samples of its unmatched
+// neighbours before they are matched. Errors are diffused in RGB, sample
+// by sample, so grid structure is carried along with color.
+func ditherAssign(ctx context.Context, tiler *Tiler, cells *cellGrid, opts Options) []int {
+	var (
+		kernel  = ditherKernels[opts.Dither]
+		choices = newConstraints(tiler, cells, opts)
+		errs    = make([][]float64, len(cells.samples))
+	)
+	for y := 0; y < cells.height && ctx.Err() == nil; y++ {
+		// Alternating direction stops errors piling up along one edge.
+		dir := 1
+		if y%2 == 1 {
+			dir = -1
+		}
+		for n := 0; n < cells.width; n++ {
+			x := n
+			if dir < 0 {
+				x = cells.width - 1 - n
+			}
+			c := cells.index(x, y)
+
+			target := make([]float64, len(cells.samples[c]))
+			for i, v := range cells.samples[c] {
+				if errs[c] != nil {
+					v += errs[c][i]
+				}
+				target[i] = clampSample(v)
+			}
+			errs[c] = nil
+
+			tile := tiler.images[choices.choose(c, tiler.pointOf(target))]
+
+			for _, d := range kernel {
+				nx, ny := x+d.dx*dir, y+d.dy
+				if nx < 0 || nx >= cells.width || ny >= cells.height {
+					continue
+				}
+				neighbour := cells.index(nx, ny)
+				if errs[neighbour] == nil {
+					errs[neighbour] = make([]float64, len(target))
+				}
+				for i := range target {
+					errs[neighbour][i] += (target[i] - tile.samples[i]) * d.weight
+				}
+			}
+		}
+	}
+	return choices.choices
+}
+
+func clampSample(v float64) float64 {
+	switch {
+	case v < 0:
+		return 0
+	case v > 0xffff:
+		return 0xffff
+	}
+	return v
+}
@@ -0,0 +1,37 @@
+package mosaic
+
+import (
+	"context"
+	"fmt"
+	"image"
+	_ "image/jpeg"
+	_ "image/png"
+	"net/http"
+)
+
+// An ImageLoader fetches and decodes the image at a URL, giving up when
+// ctx is done.
+type ImageLoader interface {
+	LoadImage(ctx context.Context, url string) (image.Image, error)
+}
+
+// WebImageLoader loads images over HTTP.
+type WebImageLoader struct{}
+
+func (WebImageLoader) LoadImage(ctx context.Context, url string) (image.Image, error) {
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := http.DefaultClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	img, _, err := image.Decode(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", url, err)
+	}
+	return img, nil
+}
@@ -0,0 +1,97 @@
+package mosaic
+
+import "sort"
+
+// kdTree is a static k-d tree over a set of points, answering exact
+// nearest neighbour queries under squared Euclidean distance.
+type kdTree struct {
+	points [][]float64
+	nodes  []kdNode
+	root   int
+}
+
+type kdNode struct {
+	point       int
+	axis        int
+	left, right int
+}
+
+func newKDTree(points [][]float64) *kdTree {
+	t := &kdTree{
+		points: points,
+		nodes:  make([]kdNode, 0, len(points)),
+	}
+	indices := make([]int, len(points))
+	for i := range indices {
+		indices[i] = i
+	}
+	t.root = t.build(indices, 0)
+	return t
+}
+
+func (t *kdTree) build(indices []int, depth int) int {
+	if len(indices) == 0 {
+		return -1
+	}
+	axis := depth % len(t.points[indices[0]])
+	sort.Slice(indices, func(i, j int) bool {
+		return t.points[indices[i]][axis] < t.points[indices[j]][axis]
+	})
+	mid := len(indices) / 2
+
+	n := len(t.nodes)
+	t.nodes = append(t.nodes, kdNode{point: indices[mid], axis: axis})
+	left := t.build(indices[:mid], depth+1)
+	right := t.build(indices[mid+1:], depth+1)
+	t.nodes[n].left = left
+	t.nodes[n].right = right
+	return n
+}
+
+// nearest returns the index of the point closest to q for which accept
+// returns true, or -1 if there is none. A nil accept allows every point.
+// Ties go to the lowest index, matching a linear scan.
+func (t *kdTree) nearest(q []float64, accept func(int) bool) int {
+	var (
+		best     = -1
+		bestDist float64
+	)
+	t.search(t.root, q, accept, &best, &bestDist)
+	return best
+}
+
+func (t *kdTree) search(n int, q []float64, accept func(int) bool, best *int, bestDist *float64) {
+	if n < 0 {
+		return
+	}
+	node := &t.nodes[n]
+	p := t.points[node.point]
+
+	if accept == nil || accept(node.point) {
+		d := squaredDistance(q, p)
+		if *best < 0 || d < *bestDist || (d == *bestDist && node.point < *best) {
+			*best = node.point
+			*bestDist = d
+		}
+	}
+
+	diff := q[node.axis] - p[node.axis]
+	near, far := node.left, node.right
+	if diff > 0 {
+		near, far = far, near
+	}
+	t.search(near, q, accept, best, bestDist)
+	// Equal distances must still be visited to keep ties deterministic.
+	if *best < 0 || diff*diff <= *bestDist {
+		t.search(far, q, accept, best, bestDist)
+	}
+}
+
+func squaredDistance(a, b []float64) float64 {
+	var sum float64
+	for i := range a {
+		d := a[i] - b[i]
+		sum += d * d
+	}
+	return sum
+}
@@ -0,0 +1,101 @@
+package mosaic
+
+import (
+	"math/rand"
+	"testing"
+)
+
+// randomPoints returns n points of the given dimension with coordinates
+// in [0, 256), as the metrics produce.
+func randomPoints(r *rand.Rand, n, dim int) [][]float64 {
+	points := make([][]float64, n)
+	for i := range points {
+		points[i] = make([]float64, dim)
+		for j := range points[i] {
+			points[i][j] = r.Float64() * 256
+		}
+	}
+	return points
+}
+
+// linearTiler returns a Tiler over points without a k-d tree, so that
+// nearest scans every tile.
+func linearTiler(points [][]float64) *Tiler {
+	t := &Tiler{metric: YCbCr, images: make([]Tile, len(points))}
+	for i, p := range points {
+		t.images[i].point = p
+	}
+	return t
+}
+
+func TestKDTreeMatchesLinearScan(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	points := randomPoints(r, 2000, 3)
+	// Repeat some points, and snap others to a coarse lattice, so that
+	// queries hit exact ties.
+	for i := 1000; i < 1200; i++ {
+		points[i] = points[i-1000]
+	}
+	for _, p := range points[1200:1600] {
+		for j := range p {
+			p[j] = float64(int(p[j]) / 64 * 64)
+		}
+	}
+	tree := newKDTree(points)
+	linear := linearTiler(points)
+
+	accepts := map[string]func(int) bool{
+		"all":  nil,
+		"even": func(i int) bool { return i%2 == 0 },
+		"late": func(i int) bool { return i >= 1000 },
+		"one":  func(i int) bool { return i == 1234 },
+		"none": func(int) bool { return false },
+	}
+	queries := append(randomPoints(r, 500, 3), points[:100]...)
+	queries = append(queries, points[1200:1300]...)
+	for name, accept := range accepts {
+		for _, q := range queries {
+			got, want := tree.nearest(q, accept), linear.nearest(q, accept)
+			if got != want {
+				t.Fatalf("%s: nearest to %v is %d, want %d", name, q, got, want)
+			}
+		}
+	}
+}
+
+func TestKDTreeTiesGoToLowestIndex(t *testing.T) {
+	points := [][]float64{{1, 1, 1}, {5, 5, 5}, {5, 5, 5}, {9, 9, 9}, {5, 5, 5}}
+	tree := newKDTree(points)
+	if got := tree.nearest([]float64{5, 5, 5}, nil); got != 1 {
+		t.Errorf("nearest to a repeated point is %d, want 1", got)
+	}
+	if got := tree.nearest([]float64{3, 3, 3}, nil); got != 0 {
+		t.Errorf("nearest to a point equidistant from 0 and 1 is %d, want 0", got)
+	}
+	if got := tree.nearest([]float64{5, 5, 5}, func(i int) bool { return i == 2 || i == 4 }); got != 2 {
+		t.Errorf("nearest accepted repeat is %d, want 2", got)
+	}
+}
+
+// benchmarkNearest matches 40k cells, as in a 200x200 mosaic, against
+// 10k tiles.
+func benchmarkNearest(b *testing.B, nearest func(q []float64) int) {
+	r := rand.New(rand.NewSource(1))
+	cells := randomPoints(r, 40000, 3)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for _, q := range cells {
+			nearest(q)
+		}
+	}
+}
+
+func BenchmarkNearestKDTree(b *testing.B) {
+	tree := newKDTree(randomPoints(rand.New(rand.NewSource(2)), 10000, 3))
+	benchmarkNearest(b, func(q []float64) int { return tree.nearest(q, nil) })
+}
+
+func BenchmarkNearestLinear(b *testing.B) {
+	linear := linearTiler(randomPoints(rand.New(rand.NewSource(2)), 10000, 3))
+	benchmarkNearest(b, func(q []float64) int { return linear.nearest(q, nil) })
+}
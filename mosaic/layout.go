@@ -0,0 +1,176 @@
+package mosaic
+
+import (
+	"fmt"
+	"image"
+	"math"
+)
+
+// A Layout selects how tiles are arranged over the output.
+type Layout int
+
+const (
+	// LayoutGrid covers the output with a uniform grid of TileSize
+	// squares.
+	LayoutGrid Layout = iota
+	// LayoutQuadtree starts from the same grid but splits any square
+	// whose region of the input is more detailed than DetailThreshold
+	// into four, down to MinTileSize. Only greedy matching is supported,
+	// so Assignment, Dither, MaxUses and MinRepeatDistance are ignored.
+	LayoutQuadtree
+	// LayoutBrick offsets alternate rows of squares by half a tile.
+	LayoutBrick
+	// LayoutHex covers the output with hexagons, each cut from a
+	// TileSize square tile.
+	LayoutHex
+	// LayoutCircles places a circle cut from each tile in every square
+	// of the grid, showing the background between them.
+	LayoutCircles
+)
+
+var layoutNames = map[Layout]string{
+	LayoutGrid:     "grid",
+	LayoutQuadtree: "quadtree",
+	LayoutBrick:    "brick",
+	LayoutHex:      "hex",
+	LayoutCircles:  "circles",
+}
+
+// ParseLayout returns the Layout with the given name, as printed by
+// Layout.String. The empty string selects LayoutGrid.
+func ParseLayout(name string) (Layout, error) {
+	if name == "" {
+		return LayoutGrid, nil
+	}
+	for l, n := range layoutNames {
+		if n == name {
+			return l, nil
+		}
+	}
+	return 0, fmt.Errorf("mosaic: unknown layout %q", name)
+}
+
+func (l Layout) String() string {
+	if name, ok := layoutNames[l]; ok {
+		return name
+	}
+	return fmt.Sprintf("Layout(%d)", int(l))
+}
+
+// A lattice positions the cells of a layout as rows of equally spaced
+// tiles, which may extend past the edges of the output. Cells are indexed
+// by column and row as in a cellGrid.
+type lattice struct {
+	cols, rows int
+	origin     image.Point
+	step       image.Point
+	// shift offsets odd rows horizontally.
+	shift int
+	size  image.Point
+	// mask, if not nil, is the shape cut from each tile.
+	mask *image.Alpha
+}
+
+// newLattice lays out cells to cover a numTilesX x numTilesY grid of
+// size squares spaced grout apart.
+func newLattice(layout Layout, numTilesX, numTilesY, size, grout int) lattice {
+	var (
+		pitch  = size + grout
+		width  = numTilesX*pitch - grout
+		height = numTilesY*pitch - grout
+		l      = lattice{
+			cols: numTilesX,
+			rows: numTilesY,
+			step: image.Pt(pitch, pitch),
+			size: image.Pt(size, size),
+		}
+	)
+	switch layout {
+	case LayoutBrick:
+		l.cols, l.origin.X, l.shift = stagger(width, pitch)
+	case LayoutHex:
+		// Hexagons w wide have points t tall, which rows overlap.
+		w, t := size/2*2, size/4
+		l.cols, l.origin.X, l.shift = stagger(width, w+grout)
+		l.origin.Y = -t
+		l.step = image.Pt(w+grout, size-t+grout)
+		l.rows = ceilDiv(height+t, l.step.Y)
+		l.mask = newMask(l.size, 1, hexagon(w, size, t))
+	case LayoutCircles:
+		l.mask = newMask(l.size, 4, circle(size))
+	}
+	return l
+}
+
+// rect returns the output rectangle of cell (i, j).
+func (l lattice) rect(i, j int) image.Rectangle {
+	min := l.origin.Add(image.Pt(i*l.step.X+(j%2)*l.shift, j*l.step.Y))
+	return image.Rectangle{Min: min, Max: min.Add(l.size)}
+}
+
+// newMask returns the coverage of each pixel of a size rectangle by a
+// shape, estimated from n x n points within the pixel.
+func newMask(size image.Point, n int, inside func(x, y float64) bool) *image.Alpha {
+	mask := image.NewAlpha(image.Rectangle{Max: size})
+	for y := 0; y < size.Y; y++ {
+		for x := 0; x < size.X; x++ {
+			covered := 0
+			for j := 0; j < n; j++ {
+				for i := 0; i < n; i++ {
+					if inside(float64(x)+(float64(i)+0.5)/float64(n), float64(y)+(float64(j)+0.5)/float64(n)) {
+						covered++
+					}
+				}
+			}
+			mask.Pix[y*mask.Stride+x] = uint8(covered * 0xff / (n * n))
+		}
+	}
+	return mask
+}
+
+// hexagon is a pointy-topped hexagon w wide and h tall whose points are t
+// tall. Edges are included, so that neighbouring hexagons leave no gaps.
+func hexagon(w, h, t int) func(x, y float64) bool {
+	half := float64(w / 2)
+	return func(x, y float64) bool {
+		d := math.Abs(x-half) * float64(t)
+		return x <= float64(w) && y*half >= d && (float64(h)-y)*half >= d
+	}
+}
+
+// roundedRect is a size rectangle whose corners are rounded with radius
+// r, or as near to it as fits.
+func roundedRect(size image.Point, r int) func(x, y float64) bool {
+	var (
+		radius = float64(min(r, min(size.X, size.Y)/2))
+		w, h   = float64(size.X), float64(size.Y)
+	)
+	return func(x, y float64) bool {
+		// Distance into the corner region, if (x, y) is in one.
+		dx := math.Max(radius-x, x-(w-radius))
+		dy := math.Max(radius-y, y-(h-radius))
+		return dx <= 0 || dy <= 0 || dx*dx+dy*dy <= radius*radius
+	}
+}
+
+// circle is the circle inscribed in a size square.
+func circle(size int) func(x, y float64) bool {
+	r := float64(size) / 2
+	return func(x, y float64) bool {
+		return (x-r)*(x-r)+(y-r)*(y-r) <= r*r
+	}
+}
+
+// stagger fits rows of cells step apart, with odd rows shifted half a
+// cell to the left, across width. The slack beyond width is split
+// between the ends so that the first and last cell of each row show.
+func stagger(width, step int) (cols, x, shift int) {
+	shift = -step / 2
+	cols = ceilDiv(width-shift, step)
+	slack := cols*step + shift - width
+	return cols, -slack / 2, shift
+}
+
+func ceilDiv(x, y int) int {
+	return (x + y - 1) / y
+}
@@ -0,0 +1,269 @@
+package mosaic
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"sort"
+	"sync"
+
+	"golang.org/x/image/draw"
+
+	"image"
+	_ "image/png"
+)
+
+const numWorkers = 10
+
+type subReddit struct {
+	Data    []post
+	Success bool
+	Status  int
+}
+
+type post struct {
+	Link string
+}
+
+// LoadTiles loads up to n tiles from source as size x size squares. If
+// ctx is done first, fetches in flight are abandoned and its error is
+// returned. If progress is not nil, it is told as pages and tiles are
+// loaded.
+func LoadTiles(ctx context.Context, source TileSource, n, size int, progress ProgressFunc) ([]image.Image, error) {
+	var (
+		wg          = new(sync.WaitGroup)
+		jobs        = make(chan job)
+		work, abort = context.WithCancel(ctx)
+	)
+	defer abort()
+
+	wg.Add(numWorkers)
+	for i := 0; i < numWorkers; i++ {
+		go imageFetcher(work, source, jobs, wg, size)
+	}
+
+	loaded := loadPages(ctx, source, jobs, n, progress)
+
+	// Nothing reads results once loadPages returns, so fetches still in
+	// flight are abandoned.
+	abort()
+	wg.Wait()
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	// Workers finish out of order; sorting by submission order keeps the
+	// tile order, and so any rendering, reproducible.
+	sort.Slice(loaded, func(i, j int) bool {
+		return loaded[i].index < loaded[j].index
+	})
+	images := make([]image.Image, len(loaded))
+	for i, tile := range loaded {
+		images[i] = tile.image
+	}
+	return images, nil
+}
+
+func loadPages(ctx context.Context, source TileSource, jobs chan<- job, total int, report ProgressFunc) []loadedTile {
+	defer close(jobs)
+	var (
+		errs           = make(chan error, numWorkers)
+		success        = make(chan loadedTile, numWorkers)
+		submittedCount int
+		receivedCount  int
+
+		pages  = newProgress(report, "pages", 0)
+		tiles  = newProgress(report, "tiles", total)
+		images []loadedTile
+	)
+
+	// record tallies a finished job and reports whether enough images
+	// have been loaded.
+	record := func(img loadedTile, err error) bool {
+		receivedCount++
+		if err != nil {
+			log.Println("Loading tile:", err)
+			tiles.add(0, 1)
+			return false
+		}
+		images = append(images, img)
+		tiles.add(1, 0)
+		return len(images) >= total
+	}
+
+	for page := 0; ; page++ {
+		locations, err := source.Page(ctx, page)
+		if err != nil {
+			log.Println(err)
+			break
+		}
+		if len(locations) == 0 {
+			break
+		}
+		pages.add(1, 0)
+
+		for _, location := range locations {
+			j := job{
+				index:   submittedCount,
+				url:     location,
+				err:     errs,
+				success: success,
+			}
+			for submitted := false; !submitted; {
+				select {
+				case jobs <- j:
+					submittedCount++
+					submitted = true
+				case err := <-errs:
+					record(loadedTile{}, err)
+				case img := <-success:
+					if record(img, nil) {
+						return images
+					}
+				case <-ctx.Done():
+					return images
+				}
+			}
+		}
+	}
+
+	for receivedCount < submittedCount {
+		select {
+		case err := <-errs:
+			record(loadedTile{}, err)
+		case img := <-success:
+			if record(img, nil) {
+				return images
+			}
+		case <-ctx.Done():
+			return images
+		}
+	}
+	return images
+}
+
+type job struct {
+	index   int
+	url     string
+	err     chan error
+	success chan loadedTile
+}
+
+type loadedTile struct {
+	index int
+	image image.Image
+}
+
+// imageFetcher loads the tiles of jobs until work is closed, giving up
+// on any result not yet received when ctx is done.
+func imageFetcher(ctx context.Context, source TileSource, work <-chan job, wg *sync.WaitGroup, size int) {
+	defer wg.Done()
+	for job := range work {
+		image, err := source.Load(ctx, job.url)
+		if err != nil {
+			select {
+			case job.err <- err:
+			case <-ctx.Done():
+			}
+			continue
+		}
+		select {
+		case job.success <- loadedTile{index: job.index, image: Thumbnail(image, size)}:
+		case <-ctx.Done():
+		}
+	}
+}
+
+type imgurSource struct {
+	imageLoader ImageLoader
+	clientID    string
+	subreddit   string
+}
+
+// NewImgurSource returns a TileSource which pages through the top images
+// posted to a subreddit, loading thumbnails through imageLoader.
+func NewImgurSource(imageLoader ImageLoader, clientID, subreddit string) TileSource {
+	return &imgurSource{
+		imageLoader: imageLoader,
+		clientID:    clientID,
+		subreddit:   subreddit,
+	}
+}
+
+func (r *imgurSource) Page(ctx context.Context, page int) ([]string, error) {
+	posts, err := r.loadSubredditPage(ctx, r.subreddit, page)
+	if err != nil {
+		return nil, err
+	}
+	links := make([]string, len(posts))
+	for i, p := range posts {
+		links[i] = p.Link
+	}
+	return links, nil
+}
+
+func (r *imgurSource) Load(ctx context.Context, url string) (image.Image, error) {
+	ending := regexp.MustCompile(`\.([a-z]{3})$`)
+	url = ending.ReplaceAllString(url, "s.$1")
+	return r.imageLoader.LoadImage(ctx, url)
+}
+
+func (r *imgurSource) loadSubredditPage(ctx context.Context, subreddit string, page int) ([]post, error) {
+	req, err := http.NewRequest("GET", fmt.Sprintf("https://api.imgur.com/3/gallery/r/%s/top/%d.json", subreddit, page), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("Authorization", "Client-ID "+r.clientID)
+	resp, err := http.DefaultClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var sub subReddit
+	err = json.NewDecoder(resp.Body).Decode(&sub)
+	if err != nil {
+		return nil, err
+	}
+
+	return sub.Data, nil
+}
+
+func resize(img image.Image, imageSize image.Rectangle) image.Image {
+	output := image.NewRGBA(imageSize)
+	draw.CatmullRom.Scale(output, imageSize, img, img.Bounds(), draw.Over, nil)
+	return output
+}
+
+// Thumbnail crops img to its largest centered square and scales it to
+// size x size. Images which are already that size are returned as is.
+func Thumbnail(img image.Image, size int) image.Image {
+	imageSize := image.Rect(0, 0, size, size)
+	if img.Bounds() == imageSize {
+		return img
+	}
+	return resize(crop(img, maxSquareInRect(img.Bounds())), imageSize)
+}
+
+func maxSquareInRect(source image.Rectangle) image.Rectangle {
+	size := source.Size()
+	var (
+		min   int
+		diffY int
+		diffX int
+	)
+	if size.Y < size.X {
+		min = size.Y
+		diffX = (size.X - min) / 2
+		diffY = (size.Y - min) / 2
+	} else {
+		min = size.X
+		diffY = (size.Y - min) / 2
+		diffX = (size.X - min) / 2
+	}
+	return image.Rect(0, 0, min, min).Add(image.Point{X: diffX, Y: diffY})
+}
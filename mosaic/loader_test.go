@@ -0,0 +1,216 @@
+package mosaic
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"runtime"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+const fakeTileSize = 2
+
+// fakeSource serves pages of numbered locations. Each tile is a flat
+// gray square whose level is its number, so tests can tell which tile
+// came back where.
+type fakeSource struct {
+	pages [][]string
+	// fail lists locations whose Load fails.
+	fail map[string]bool
+	// block makes Load wait until its context is done.
+	block bool
+
+	mu    sync.Mutex
+	paged []int
+}
+
+// newFakeSource returns a source of count tiles, perPage to a page.
+func newFakeSource(count, perPage int) *fakeSource {
+	s := &fakeSource{fail: make(map[string]bool)}
+	for i := 0; i < count; i += perPage {
+		var page []string
+		for j := i; j < i+perPage && j < count; j++ {
+			page = append(page, strconv.Itoa(j))
+		}
+		s.pages = append(s.pages, page)
+	}
+	return s
+}
+
+func (s *fakeSource) Page(ctx context.Context, page int) ([]string, error) {
+	s.mu.Lock()
+	s.paged = append(s.paged, page)
+	s.mu.Unlock()
+	if page >= len(s.pages) {
+		return nil, nil
+	}
+	return s.pages[page], nil
+}
+
+func (s *fakeSource) Load(ctx context.Context, location string) (image.Image, error) {
+	if s.block {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+	if s.fail[location] {
+		return nil, errors.New("fake failure: " + location)
+	}
+	n, err := strconv.Atoi(location)
+	if err != nil {
+		return nil, err
+	}
+	img := image.NewGray(image.Rect(0, 0, fakeTileSize, fakeTileSize))
+	for i := range img.Pix {
+		img.Pix[i] = uint8(n)
+	}
+	return img, nil
+}
+
+// tileNumber returns the number of a tile loaded from a fakeSource.
+func tileNumber(img image.Image) int {
+	return int(color.GrayModel.Convert(img.At(0, 0)).(color.Gray).Y)
+}
+
+// slowProgress holds up the loader on every report, so that finished
+// fetches pile up unread.
+func slowProgress(Progress) {
+	time.Sleep(time.Millisecond)
+}
+
+// checkNoLeaks fails t if the number of goroutines does not fall back to
+// before within a second.
+func checkNoLeaks(t *testing.T, before int) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for runtime.NumGoroutine() > before {
+		if time.Now().After(deadline) {
+			buf := make([]byte, 1<<16)
+			t.Fatalf("%d goroutines left running, want %d:\n%s", runtime.NumGoroutine(), before, buf[:runtime.Stack(buf, true)])
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// loadWithin runs LoadTiles, failing t if it does not return within a
+// few seconds.
+func loadWithin(t *testing.T, ctx context.Context, source TileSource, n int, progress ProgressFunc) ([]image.Image, error) {
+	t.Helper()
+	type result struct {
+		images []image.Image
+		err    error
+	}
+	done := make(chan result, 1)
+	go func() {
+		images, err := LoadTiles(ctx, source, n, fakeTileSize, progress)
+		done <- result{images, err}
+	}()
+	select {
+	case r := <-done:
+		return r.images, r.err
+	case <-time.After(5 * time.Second):
+		t.Fatal("LoadTiles did not return")
+		return nil, nil
+	}
+}
+
+func TestLoadTilesPagesInOrder(t *testing.T) {
+	source := newFakeSource(25, 10)
+	for _, n := range []string{"3", "12", "24"} {
+		source.fail[n] = true
+	}
+	var (
+		mu    sync.Mutex
+		tiles Progress
+		pages Progress
+	)
+	record := func(p Progress) {
+		mu.Lock()
+		defer mu.Unlock()
+		switch p.Stage {
+		case "tiles":
+			tiles = p
+		case "pages":
+			pages = p
+		}
+	}
+
+	images, err := loadWithin(t, context.Background(), source, 100, record)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if fmt.Sprint(source.paged) != "[0 1 2 3]" {
+		t.Errorf("fetched pages %v, want [0 1 2 3], stopping at the first empty page", source.paged)
+	}
+	if pages.Done != 3 {
+		t.Errorf("reported %d pages, want 3", pages.Done)
+	}
+	if tiles.Done != 22 || tiles.Failed != 3 || tiles.Total != 100 {
+		t.Errorf("reported %+v, want 22 done and 3 failed of 100", tiles)
+	}
+	var got []int
+	for _, img := range images {
+		got = append(got, tileNumber(img))
+	}
+	for i := 1; i < len(got); i++ {
+		if got[i] <= got[i-1] {
+			t.Fatalf("tiles %v are not in submission order", got)
+		}
+	}
+	if len(got) != 22 {
+		t.Errorf("loaded %d tiles, want 22", len(got))
+	}
+}
+
+func TestLoadTilesCancelledWhileFetching(t *testing.T) {
+	before := runtime.NumGoroutine()
+	source := newFakeSource(100, 100)
+	source.block = true
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	_, err := loadWithin(t, ctx, source, 50, nil)
+	if err != context.DeadlineExceeded {
+		t.Fatalf("got error %v, want %v", err, context.DeadlineExceeded)
+	}
+	checkNoLeaks(t, before)
+}
+
+func TestLoadTilesCancelledWithResultsUnread(t *testing.T) {
+	before := runtime.NumGoroutine()
+	for trial := 0; trial < 5; trial++ {
+		source := newFakeSource(1000, 100)
+		for i := 0; i < 1000; i += 2 {
+			source.fail[strconv.Itoa(i)] = true
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+		_, err := loadWithin(t, ctx, source, 1000, slowProgress)
+		cancel()
+		if err != context.DeadlineExceeded {
+			t.Fatalf("trial %d: got error %v, want %v", trial, err, context.DeadlineExceeded)
+		}
+	}
+	checkNoLeaks(t, before)
+}
+
+func TestLoadTilesStopsWhenEnoughLoaded(t *testing.T) {
+	before := runtime.NumGoroutine()
+	for trial := 0; trial < 5; trial++ {
+		source := newFakeSource(1000, 100)
+
+		images, err := loadWithin(t, context.Background(), source, 5, slowProgress)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(images) != 5 {
+			t.Fatalf("trial %d: loaded %d tiles, want 5", trial, len(images))
+		}
+	}
+	checkNoLeaks(t, before)
+}
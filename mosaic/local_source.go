@@ -0,0 +1,253 @@
+package mosaic
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	_ "image/gif"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+const localPageSize = 100
+
+var imageExtensions = map[string]bool{
+	".jpg":  true,
+	".jpeg": true,
+	".png":  true,
+	".gif":  true,
+}
+
+// ErrNotArchive is returned by OpenLocalSource for paths which are
+// neither a directory nor a supported archive.
+var ErrNotArchive = errors.New("mosaic: not a directory or .zip/.tar archive")
+
+// LocalSource serves every image in a directory tree or archive.
+type LocalSource struct {
+	names  []string
+	open   func(name string) (io.ReadCloser, error)
+	closer io.Closer
+}
+
+// OpenLocalSource opens a directory, or a .zip, .tar or .tar.gz archive,
+// as a TileSource. The source should be closed when no longer needed.
+func OpenLocalSource(path string) (*LocalSource, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if info.IsDir() {
+		return openDirSource(path)
+	}
+
+	lower := strings.ToLower(path)
+	switch {
+	case strings.HasSuffix(lower, ".zip"):
+		return openZipSource(path)
+	case strings.HasSuffix(lower, ".tar"):
+		return openTarSource(path, false)
+	case strings.HasSuffix(lower, ".tar.gz"), strings.HasSuffix(lower, ".tgz"):
+		return openTarSource(path, true)
+	}
+	return nil, ErrNotArchive
+}
+
+func isImageName(name string) bool {
+	return imageExtensions[strings.ToLower(filepath.Ext(name))]
+}
+
+func openDirSource(dir string) (*LocalSource, error) {
+	var names []string
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.Mode().IsRegular() && isImageName(path) {
+			names = append(names, path)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &LocalSource{
+		names: names,
+		open: func(name string) (io.ReadCloser, error) {
+			return os.Open(name)
+		},
+	}, nil
+}
+
+func openZipSource(path string) (*LocalSource, error) {
+	archive, err := zip.OpenReader(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		names []string
+		files = make(map[string]*zip.File)
+	)
+	for _, f := range archive.File {
+		if f.Mode().IsRegular() && isImageName(f.Name) {
+			names = append(names, f.Name)
+			files[f.Name] = f
+		}
+	}
+	sort.Strings(names)
+	return &LocalSource{
+		names: names,
+		open: func(name string) (io.ReadCloser, error) {
+			return files[name].Open()
+		},
+		closer: archive,
+	}, nil
+}
+
+// openTarSource indexes the images in a tar archive, reading each one
+// only when it is loaded. Compressed archives cannot be read out of
+// order, so they are first decompressed to a temporary file.
+func openTarSource(path string, gzipped bool) (*LocalSource, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	var archive interface {
+		io.ReadSeeker
+		io.ReaderAt
+		io.Closer
+	} = f
+	if gzipped {
+		archive, err = gunzipTemp(f)
+		f.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var (
+		names   []string
+		entries = make(map[string]tarEntry)
+		tr      = tar.NewReader(archive)
+	)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			archive.Close()
+			return nil, err
+		}
+		if hdr.Typeflag != tar.TypeReg || !isImageName(hdr.Name) {
+			continue
+		}
+		// tar reads whole headers and no further, so the archive is left
+		// at the start of the entry's contents.
+		offset, err := archive.Seek(0, io.SeekCurrent)
+		if err != nil {
+			archive.Close()
+			return nil, err
+		}
+		names = append(names, hdr.Name)
+		entries[hdr.Name] = tarEntry{offset: offset, size: hdr.Size}
+	}
+	sort.Strings(names)
+	return &LocalSource{
+		names: names,
+		open: func(name string) (io.ReadCloser, error) {
+			entry := entries[name]
+			return ioutil.NopCloser(io.NewSectionReader(archive, entry.offset, entry.size)), nil
+		},
+		closer: archive,
+	}, nil
+}
+
+// tarEntry locates the contents of a file within a tar archive.
+type tarEntry struct {
+	offset, size int64
+}
+
+// tempFile is a temporary file which is removed when closed.
+type tempFile struct {
+	*os.File
+}
+
+func (t tempFile) Close() error {
+	err := t.File.Close()
+	if removeErr := os.Remove(t.Name()); err == nil {
+		err = removeErr
+	}
+	return err
+}
+
+// gunzipTemp decompresses r to a temporary file.
+func gunzipTemp(r io.Reader) (tempFile, error) {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return tempFile{}, err
+	}
+	defer gz.Close()
+
+	f, err := ioutil.TempFile("", "tiles-")
+	if err != nil {
+		return tempFile{}, err
+	}
+	t := tempFile{f}
+	_, err = io.Copy(t, gz)
+	if err == nil {
+		_, err = t.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		t.Close()
+		return tempFile{}, err
+	}
+	return t, nil
+}
+
+func (l *LocalSource) Page(ctx context.Context, page int) ([]string, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	start := page * localPageSize
+	if start >= len(l.names) {
+		return nil, nil
+	}
+	end := start + localPageSize
+	if end > len(l.names) {
+		end = len(l.names)
+	}
+	return l.names[start:end], nil
+}
+
+func (l *LocalSource) Load(ctx context.Context, name string) (image.Image, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	r, err := l.open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	img, _, err := image.Decode(r)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", name, err)
+	}
+	return img, nil
+}
+
+func (l *LocalSource) Close() error {
+	if l.closer == nil {
+		return nil
+	}
+	return l.closer.Close()
+}
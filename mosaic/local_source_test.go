@@ -0,0 +1,104 @@
+package mosaic
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"image"
+	"image/png"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeTar writes a tar archive to path holding a flat gray image for
+// each entry of levels, named by its key, and a text file.
+func writeTar(t *testing.T, path string, gzipped bool, levels map[string]uint8) {
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var w io.Writer = f
+	if gzipped {
+		gz := gzip.NewWriter(f)
+		defer gz.Close()
+		w = gz
+	}
+	tw := tar.NewWriter(w)
+	defer tw.Close()
+
+	add := func(name string, data []byte) {
+		err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(data)), Typeflag: tar.TypeReg})
+		if err == nil {
+			_, err = tw.Write(data)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	for name, level := range levels {
+		img := image.NewGray(image.Rect(0, 0, 3, 3))
+		for i := range img.Pix {
+			img.Pix[i] = level
+		}
+		var buf bytes.Buffer
+		if err := png.Encode(&buf, img); err != nil {
+			t.Fatal(err)
+		}
+		add(name, buf.Bytes())
+	}
+	add("README.txt", []byte("not a tile"))
+}
+
+func TestTarSourceLoadsLazily(t *testing.T) {
+	levels := map[string]uint8{
+		"b.png":                              20,
+		"a/c.png":                            30,
+		strings.Repeat("long/", 40) + ".png": 40,
+	}
+	for _, gzipped := range []bool{false, true} {
+		dir := t.TempDir()
+		t.Setenv("TMPDIR", dir)
+		path := filepath.Join(dir, "tiles.tar")
+		if gzipped {
+			path += ".gz"
+		}
+		writeTar(t, path, gzipped, levels)
+
+		source, err := OpenLocalSource(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		names, err := source.Page(context.Background(), 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(names) != len(levels) {
+			t.Errorf("gzipped %v: listed %v, want the %d images", gzipped, names, len(levels))
+		}
+		// Load out of order, and twice over, as workers would.
+		for i := 0; i < 2; i++ {
+			for j := len(names) - 1; j >= 0; j-- {
+				img, err := source.Load(context.Background(), names[j])
+				if err != nil {
+					t.Fatal(err)
+				}
+				if got := tileNumber(img); got != int(levels[names[j]]) {
+					t.Errorf("gzipped %v: %s has level %d, want %d", gzipped, names[j], got, levels[names[j]])
+				}
+			}
+		}
+
+		if err := source.Close(); err != nil {
+			t.Fatal(err)
+		}
+		left, _ := filepath.Glob(filepath.Join(dir, "tiles-*"))
+		if len(left) != 0 {
+			t.Errorf("gzipped %v: left %v behind", gzipped, left)
+		}
+	}
+}
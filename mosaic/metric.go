@@ -0,0 +1,195 @@
+package mosaic
+
+import (
+	"fmt"
+	"image/color"
+	"math"
+)
+
+// A Metric selects the color space tiles are compared in and how
+// distance is measured there.
+type Metric int
+
+const (
+	// YCbCr compares 8-bit YCbCr values by Euclidean distance.
+	YCbCr Metric = iota
+	// RGB compares 8-bit RGB values by Euclidean distance.
+	RGB
+	// CIE76 compares CIELAB values by Euclidean distance (ΔE*76).
+	CIE76
+	// CIEDE2000 compares CIELAB values by ΔE*00. It is the most
+	// perceptually accurate metric, but cannot use the spatial index so
+	// matching scans every tile.
+	CIEDE2000
+)
+
+var metricNames = map[Metric]string{
+	YCbCr:     "ycbcr",
+	RGB:       "rgb",
+	CIE76:     "cie76",
+	CIEDE2000: "ciede2000",
+}
+
+// ParseMetric returns the Metric with the given name, as printed by
+// Metric.String. The empty string selects YCbCr.
+func ParseMetric(name string) (Metric, error) {
+	if name == "" {
+		return YCbCr, nil
+	}
+	for m, n := range metricNames {
+		if n == name {
+			return m, nil
+		}
+	}
+	return 0, fmt.Errorf("mosaic: unknown color metric %q", name)
+}
+
+func (m Metric) String() string {
+	if name, ok := metricNames[m]; ok {
+		return name
+	}
+	return fmt.Sprintf("Metric(%d)", int(m))
+}
+
+// point converts c into the metric's color space.
+func (m Metric) point(c color.Color) []float64 {
+	switch m {
+	case RGB:
+		r, g, b, _ := c.RGBA()
+		return []float64{float64(r >> 8), float64(g >> 8), float64(b >> 8)}
+	case CIE76, CIEDE2000:
+		return labPoint(c)
+	}
+	r, g, b, _ := c.RGBA()
+	y, u, v := color.RGBToYCbCr(uint8(r>>8), uint8(g>>8), uint8(b>>8))
+	return []float64{float64(y), float64(u), float64(v)}
+}
+
+// euclidean reports whether squared Euclidean distance between points
+// orders colors the same way the metric does.
+func (m Metric) euclidean() bool {
+	return m != CIEDE2000
+}
+
+// distance measures the difference between two vectors of points, as the
+// sum of squared per-point distances.
+func (m Metric) distance(a, b []float64) float64 {
+	if m.euclidean() {
+		return squaredDistance(a, b)
+	}
+	var sum float64
+	for i := 0; i+3 <= len(a); i += 3 {
+		d := ciede2000(a[i:i+3], b[i:i+3])
+		sum += d * d
+	}
+	return sum
+}
+
+// labPoint converts c from sRGB to CIELAB under a D65 white point.
+func labPoint(c color.Color) []float64 {
+	r, g, b, _ := c.RGBA()
+	rl := srgbToLinear(float64(r) / 0xffff)
+	gl := srgbToLinear(float64(g) / 0xffff)
+	bl := srgbToLinear(float64(b) / 0xffff)
+
+	x := 0.4124564*rl + 0.3575761*gl + 0.1804375*bl
+	y := 0.2126729*rl + 0.7151522*gl + 0.0721750*bl
+	z := 0.0193339*rl + 0.1191920*gl + 0.9503041*bl
+
+	fx := labF(x / 0.95047)
+	fy := labF(y)
+	fz := labF(z / 1.08883)
+	return []float64{116*fy - 16, 500 * (fx - fy), 200 * (fy - fz)}
+}
+
+func srgbToLinear(v float64) float64 {
+	if v <= 0.04045 {
+		return v / 12.92
+	}
+	return math.Pow((v+0.055)/1.055, 2.4)
+}
+
+func labF(t float64) float64 {
+	if t > 216.0/24389 {
+		return math.Cbrt(t)
+	}
+	return (24389.0/27*t + 16) / 116
+}
+
+// ciede2000 returns ΔE*00 between two CIELAB colors, following Sharma,
+// Wu and Dalal, "The CIEDE2000 Color-Difference Formula" (2005).
+func ciede2000(lab1, lab2 []float64) float64 {
+	l1, a1, b1 := lab1[0], lab1[1], lab1[2]
+	l2, a2, b2 := lab2[0], lab2[1], lab2[2]
+
+	cBar := (math.Hypot(a1, b1) + math.Hypot(a2, b2)) / 2
+	g := 0.5 * (1 - math.Sqrt(pow7(cBar)/(pow7(cBar)+pow7(25))))
+	a1p := (1 + g) * a1
+	a2p := (1 + g) * a2
+	c1p := math.Hypot(a1p, b1)
+	c2p := math.Hypot(a2p, b2)
+	h1p := hueAngle(b1, a1p)
+	h2p := hueAngle(b2, a2p)
+
+	dLp := l2 - l1
+	dCp := c2p - c1p
+	var dhp float64
+	if c1p*c2p != 0 {
+		dhp = h2p - h1p
+		if dhp > 180 {
+			dhp -= 360
+		} else if dhp < -180 {
+			dhp += 360
+		}
+	}
+	dHp := 2 * math.Sqrt(c1p*c2p) * math.Sin(radians(dhp/2))
+
+	lBarp := (l1 + l2) / 2
+	cBarp := (c1p + c2p) / 2
+	hBarp := h1p + h2p
+	if c1p*c2p != 0 {
+		switch {
+		case math.Abs(h1p-h2p) <= 180:
+			hBarp = (h1p + h2p) / 2
+		case h1p+h2p < 360:
+			hBarp = (h1p + h2p + 360) / 2
+		default:
+			hBarp = (h1p + h2p - 360) / 2
+		}
+	}
+
+	t := 1 - 0.17*math.Cos(radians(hBarp-30)) +
+		0.24*math.Cos(radians(2*hBarp)) +
+		0.32*math.Cos(radians(3*hBarp+6)) -
+		0.20*math.Cos(radians(4*hBarp-63))
+	dTheta := 30 * math.Exp(-math.Pow((hBarp-275)/25, 2))
+	rc := 2 * math.Sqrt(pow7(cBarp)/(pow7(cBarp)+pow7(25)))
+	lDiff := (lBarp - 50) * (lBarp - 50)
+	sl := 1 + 0.015*lDiff/math.Sqrt(20+lDiff)
+	sc := 1 + 0.045*cBarp
+	sh := 1 + 0.015*cBarp*t
+	rt := -math.Sin(radians(2*dTheta)) * rc
+
+	dl, dc, dh := dLp/sl, dCp/sc, dHp/sh
+	return math.Sqrt(dl*dl + dc*dc + dh*dh + rt*dc*dh)
+}
+
+func hueAngle(b, a float64) float64 {
+	if a == 0 && b == 0 {
+		return 0
+	}
+	h := math.Atan2(b, a) * 180 / math.Pi
+	if h < 0 {
+		h += 360
+	}
+	return h
+}
+
+func radians(deg float64) float64 {
+	return deg * math.Pi / 180
+}
+
+func pow7(x float64) float64 {
+	x2 := x * x
+	return x2 * x2 * x2 * x
+}
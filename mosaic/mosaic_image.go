@@ -0,0 +1,224 @@
+package mosaic
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"sort"
+)
+
+// A Mosaic is a set of tiles placed over a canvas, optionally with the
+// source image overlaid on top. Tiles may differ in size; any part of
+// the canvas not covered by a tile shows the background color.
+type Mosaic struct {
+	bounds     image.Rectangle
+	tiles      []placedTile
+	background color.Color
+	overlay    *overlay
+
+	// buckets lists the tiles overlapping each bucketSize square of the
+	// canvas, in row-major order, so At need not search every tile.
+	bucketSize int
+	bucketCols int
+	buckets    [][]int32
+}
+
+// placedTile is a tile image drawn into rect. The image has the same size
+// as rect but may have any origin. If mask is not nil, it gives the
+// coverage of each pixel of rect, relative to rect.Min, and the
+// background shows through wherever the tile is not fully opaque.
+type placedTile struct {
+	rect  image.Rectangle
+	image image.Image
+	mask  *image.Alpha
+}
+
+// coverage returns how much of (x, y) the tile covers, from 0 to 0xff.
+func (t *placedTile) coverage(x, y int) uint8 {
+	if t.mask == nil {
+		return 0xff
+	}
+	return t.mask.Pix[(y-t.rect.Min.Y)*t.mask.Stride+x-t.rect.Min.X]
+}
+
+func newMosaic(bounds image.Rectangle, tiles []placedTile) Mosaic {
+	m := Mosaic{
+		bounds:     bounds,
+		tiles:      tiles,
+		background: color.Black,
+	}
+	for _, t := range tiles {
+		size := min(t.rect.Dx(), t.rect.Dy())
+		if m.bucketSize == 0 || size < m.bucketSize {
+			m.bucketSize = size
+		}
+	}
+	m.bucketSize = max(m.bucketSize, 1)
+
+	m.bucketCols = (bounds.Dx() + m.bucketSize - 1) / m.bucketSize
+	rows := (bounds.Dy() + m.bucketSize - 1) / m.bucketSize
+	m.buckets = make([][]int32, m.bucketCols*rows)
+	for i, t := range tiles {
+		r := t.rect.Intersect(bounds).Sub(bounds.Min)
+		if r.Empty() {
+			continue
+		}
+		for by := r.Min.Y / m.bucketSize; by <= (r.Max.Y-1)/m.bucketSize; by++ {
+			for bx := r.Min.X / m.bucketSize; bx <= (r.Max.X-1)/m.bucketSize; bx++ {
+				b := by*m.bucketCols + bx
+				m.buckets[b] = append(m.buckets[b], int32(i))
+			}
+		}
+	}
+	return m
+}
+
+var _ image.Image = Mosaic{}
+
+// tileAt returns the tile covering (x, y), or nil if there is none.
+// Where masked tiles overlap, the first to cover the point wins.
+func (m Mosaic) tileAt(x, y int) *placedTile {
+	p := image.Pt(x, y)
+	if !p.In(m.bounds) {
+		return nil
+	}
+	p = p.Sub(m.bounds.Min)
+	for _, i := range m.buckets[(p.Y/m.bucketSize)*m.bucketCols+p.X/m.bucketSize] {
+		t := &m.tiles[i]
+		if image.Pt(x, y).In(t.rect) && t.coverage(x, y) > 0 {
+			return t
+		}
+	}
+	return nil
+}
+
+func (m Mosaic) At(x, y int) color.Color {
+	c := m.background
+	if t := m.tileAt(x, y); t != nil {
+		origin := t.image.Bounds().Min
+		c = t.image.At(x-t.rect.Min.X+origin.X, y-t.rect.Min.Y+origin.Y)
+		if a := t.coverage(x, y); a < 0xff {
+			c = mix(m.background, c, a)
+		}
+	}
+	if m.overlay != nil {
+		return m.overlay.blend(c, x, y)
+	}
+	return c
+}
+
+// DrawTo renders the part of m within r into dst, at the same
+// coordinates. It draws whole tiles at a time, so it is much faster than
+// reading m with At.
+func (m Mosaic) DrawTo(dst *image.RGBA, r image.Rectangle) {
+	r = r.Intersect(m.bounds).Intersect(dst.Bounds())
+	if r.Empty() {
+		return
+	}
+	draw.Draw(dst, r, image.NewUniform(m.background), image.ZP, draw.Src)
+
+	// Tiles are drawn last to first so that, as in At, the first of any
+	// overlapping tiles wins.
+	tiles := m.tilesIn(r)
+	for n := len(tiles) - 1; n >= 0; n-- {
+		t := &m.tiles[tiles[n]]
+		tr := t.rect.Intersect(r)
+		offset := tr.Min.Sub(t.rect.Min)
+		sp := offset.Add(t.image.Bounds().Min)
+		if t.mask == nil {
+			draw.Draw(dst, tr, t.image, sp, draw.Src)
+		} else {
+			draw.DrawMask(dst, tr, t.image, sp, t.mask, offset, draw.Over)
+		}
+	}
+	if m.overlay != nil {
+		m.overlay.draw(dst, r)
+	}
+}
+
+// tilesIn returns the indexes of the tiles overlapping r, in order.
+func (m Mosaic) tilesIn(r image.Rectangle) []int {
+	r = r.Sub(m.bounds.Min)
+	var (
+		seen  = make(map[int32]bool)
+		tiles []int
+	)
+	for by := r.Min.Y / m.bucketSize; by <= (r.Max.Y-1)/m.bucketSize; by++ {
+		for bx := r.Min.X / m.bucketSize; bx <= (r.Max.X-1)/m.bucketSize; bx++ {
+			for _, i := range m.buckets[by*m.bucketCols+bx] {
+				if !seen[i] {
+					seen[i] = true
+					tiles = append(tiles, int(i))
+				}
+			}
+		}
+	}
+	sort.Ints(tiles)
+	return tiles
+}
+
+// SubImage renders the part of m within r. The result is an *image.RGBA,
+// which the image/draw and image/png packages handle efficiently.
+func (m Mosaic) SubImage(r image.Rectangle) image.Image {
+	r = r.Intersect(m.bounds)
+	dst := image.NewRGBA(r)
+	m.DrawTo(dst, r)
+	return dst
+}
+
+// Opaque reports whether every pixel of m is opaque, assuming tiles
+// which cannot say are not.
+func (m Mosaic) Opaque() bool {
+	if _, _, _, a := m.background.RGBA(); a != 0xffff {
+		return false
+	}
+	for _, t := range m.tiles {
+		o, ok := t.image.(interface {
+			Opaque() bool
+		})
+		if !ok || !o.Opaque() {
+			return false
+		}
+	}
+	return true
+}
+
+func (m Mosaic) Bounds() image.Rectangle {
+	return m.bounds
+}
+
+func (m Mosaic) ColorModel() color.Model {
+	return color.RGBAModel
+}
+
+// mix blends fg over bg with the given 8-bit coverage.
+func mix(bg, fg color.Color, coverage uint8) color.Color {
+	var (
+		r0, g0, b0, a0 = bg.RGBA()
+		r1, g1, b1, a1 = fg.RGBA()
+		w              = uint32(coverage) * 0x101
+	)
+	blend := func(x0, x1 uint32) uint16 {
+		return uint16((x1*w + x0*(0xffff-w)) / 0xffff)
+	}
+	return color.RGBA64{
+		R: blend(r0, r1),
+		G: blend(g0, g1),
+		B: blend(b0, b1),
+		A: blend(a0, a1),
+	}
+}
+
+func min(x, y int) int {
+	if x < y {
+		return x
+	}
+	return y
+}
+
+func max(x, y int) int {
+	if x > y {
+		return x
+	}
+	return y
+}
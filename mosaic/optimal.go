@@ -0,0 +1,221 @@
+package mosaic
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"math/rand"
+)
+
+// An Assignment selects how cells are matched to tiles.
+type Assignment int
+
+const (
+	// Greedy gives each cell its nearest tile, subject to the MaxUses and
+	// MinRepeatDistance options.
+	Greedy Assignment = iota
+	// Optimal minimises the total color distance over all cells, with
+	// every tile used at most MaxUses times. When MaxUses is zero or too
+	// small to cover every cell, each tile gets just enough uses, so a
+	// grid with as many cells as tiles uses every tile exactly once.
+	// MinRepeatDistance is ignored.
+	Optimal
+)
+
+var assignmentNames = map[Assignment]string{
+	Greedy:  "greedy",
+	Optimal: "optimal",
+}
+
+// ParseAssignment returns the Assignment with the given name, as printed
+// by Assignment.String. The empty string selects Greedy.
+func ParseAssignment(name string) (Assignment, error) {
+	if name == "" {
+		return Greedy, nil
+	}
+	for a, n := range assignmentNames {
+		if n == name {
+			return a, nil
+		}
+	}
+	return 0, fmt.Errorf("mosaic: unknown assignment %q", name)
+}
+
+func (a Assignment) String() string {
+	if name, ok := assignmentNames[a]; ok {
+		return name
+	}
+	return fmt.Sprintf("Assignment(%d)", int(a))
+}
+
+// maxOptimalWork bounds the n²m steps of an exact assignment. Larger
+// problems are solved approximately.
+const maxOptimalWork = 1e9
+
+// approximateSwapRounds is the number of improvement attempts per cell
+// made by the approximate solver.
+const approximateSwapRounds = 20
+
+// optimalAssign solves the min-cost assignment of cells to tile slots,
+// where each source image has a fixed number of slots and a cell placed
+// in a slot takes whichever variant of the image suits it best.
+func optimalAssign(ctx context.Context, tiler *Tiler, cells *cellGrid, opts Options) []int {
+	var (
+		n    = len(cells.points)
+		t    = len(tiler.sources)
+		uses = opts.MaxUses
+	)
+	if uses*t < n {
+		uses = (n + t - 1) / t
+	}
+	m := uses * t
+
+	if float64(n)*float64(n)*float64(m) > maxOptimalWork {
+		return approximateAssign(ctx, tiler, cells, opts, uses)
+	}
+
+	var (
+		costs    = make([]float64, n*t)
+		variants = make([]int, n*t)
+	)
+	parallelMap(ctx, n, func(i int) {
+		for j := 0; j < t; j++ {
+			variants[i*t+j], costs[i*t+j] = tiler.bestVariant(cells.points[i], j)
+		}
+	})
+
+	slots := hungarian(ctx, n, m, func(i, j int) float64 {
+		return costs[i*t+j%t]
+	})
+	for i, slot := range slots {
+		slots[i] = variants[i*t+slot%t]
+	}
+	cells.matched.add(n, 0)
+	return slots
+}
+
+// hungarian solves the rectangular assignment problem for n rows and
+// m >= n columns in O(n²m), returning the column assigned to each row.
+// If ctx is done first, the rows not yet placed are left in column 0.
+func hungarian(ctx context.Context, n, m int, cost func(i, j int) float64) []int {
+	var (
+		u    = make([]float64, n+1)
+		v    = make([]float64, m+1)
+		p    = make([]int, m+1)
+		way  = make([]int, m+1)
+		minv = make([]float64, m+1)
+		used = make([]bool, m+1)
+	)
+	for i := 1; i <= n && ctx.Err() == nil; i++ {
+		p[0] = i
+		j0 := 0
+		for j := range minv {
+			minv[j] = math.Inf(1)
+			used[j] = false
+		}
+		for p[j0] != 0 {
+			used[j0] = true
+			var (
+				i0    = p[j0]
+				delta = math.Inf(1)
+				j1    int
+			)
+			for j := 1; j <= m; j++ {
+				if used[j] {
+					continue
+				}
+				cur := cost(i0-1, j-1) - u[i0] - v[j]
+				if cur < minv[j] {
+					minv[j] = cur
+					way[j] = j0
+				}
+				if minv[j] < delta {
+					delta = minv[j]
+					j1 = j
+				}
+			}
+			for j := 0; j <= m; j++ {
+				if used[j] {
+					u[p[j]] += delta
+					v[j] -= delta
+				} else {
+					minv[j] -= delta
+				}
+			}
+			j0 = j1
+		}
+		for j0 != 0 {
+			j1 := way[j0]
+			p[j0] = p[j1]
+			j0 = j1
+		}
+	}
+
+	assignment := make([]int, n)
+	for j := 1; j <= m; j++ {
+		if p[j] != 0 {
+			assignment[p[j]-1] = j - 1
+		}
+	}
+	return assignment
+}
+
+// approximateAssign starts from a capacity-limited greedy assignment and
+// improves it with random swaps between cells and moves to images with
+// spare uses, keeping any change which lowers the total cost.
+func approximateAssign(ctx context.Context, tiler *Tiler, cells *cellGrid, opts Options, uses int) []int {
+	greedy := opts
+	greedy.MaxUses = uses
+	greedy.MinRepeatDistance = 0
+	choices := constrainedAssign(ctx, tiler, cells, greedy)
+	if ctx.Err() != nil {
+		return choices
+	}
+
+	var (
+		n       = len(cells.points)
+		t       = len(tiler.sources)
+		sources = make([]int, n)
+		count   = make([]int, t)
+		rng     = rand.New(rand.NewSource(opts.Seed))
+	)
+	for i, c := range choices {
+		sources[i] = tiler.images[c].source
+		count[sources[i]]++
+	}
+	cost := func(cell, source int) float64 {
+		_, d := tiler.bestVariant(cells.points[cell], source)
+		return d
+	}
+
+	for round := 0; round < approximateSwapRounds*n; round++ {
+		if round%n == 0 && ctx.Err() != nil {
+			return choices
+		}
+		a := rng.Intn(n)
+		sa := sources[a]
+
+		if source := rng.Intn(t); count[source] < uses {
+			if cost(a, source) < cost(a, sa) {
+				sources[a] = source
+				count[sa]--
+				count[source]++
+			}
+			continue
+		}
+
+		b := rng.Intn(n)
+		sb := sources[b]
+		if sa == sb {
+			continue
+		}
+		if cost(a, sb)+cost(b, sa) < cost(a, sa)+cost(b, sb) {
+			sources[a], sources[b] = sb, sa
+		}
+	}
+
+	for i, source := range sources {
+		choices[i], _ = tiler.bestVariant(cells.points[i], source)
+	}
+	return choices
+}
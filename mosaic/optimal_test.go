@@ -0,0 +1,66 @@
+package mosaic
+
+import (
+	"context"
+	"math"
+	"math/rand"
+	"testing"
+)
+
+// bruteForceAssign returns the lowest total cost of assigning each of n
+// rows a distinct one of m columns, trying every assignment.
+func bruteForceAssign(n, m int, cost func(i, j int) float64) float64 {
+	used := make([]bool, m)
+	var search func(i int) float64
+	search = func(i int) float64 {
+		if i == n {
+			return 0
+		}
+		best := math.Inf(1)
+		for j := 0; j < m; j++ {
+			if used[j] {
+				continue
+			}
+			used[j] = true
+			best = math.Min(best, cost(i, j)+search(i+1))
+			used[j] = false
+		}
+		return best
+	}
+	return search(0)
+}
+
+func TestHungarianMatchesBruteForce(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	for trial := 0; trial < 200; trial++ {
+		n := 1 + r.Intn(6)
+		m := n + r.Intn(3)
+		costs := make([][]float64, n)
+		for i := range costs {
+			costs[i] = make([]float64, m)
+			for j := range costs[i] {
+				// Small integers make ties, and so alternative optima,
+				// common.
+				costs[i][j] = float64(r.Intn(10))
+			}
+		}
+		cost := func(i, j int) float64 { return costs[i][j] }
+
+		columns := hungarian(context.Background(), n, m, cost)
+		if len(columns) != n {
+			t.Fatalf("trial %d: assigned %d rows, want %d", trial, len(columns), n)
+		}
+		used := make(map[int]bool)
+		var total float64
+		for i, j := range columns {
+			if j < 0 || j >= m || used[j] {
+				t.Fatalf("trial %d: assignment %v does not give each row its own column", trial, columns)
+			}
+			used[j] = true
+			total += cost(i, j)
+		}
+		if want := bruteForceAssign(n, m, cost); total != want {
+			t.Fatalf("trial %d: assignment %v of %v costs %v, want %v", trial, columns, costs, total, want)
+		}
+	}
+}
@@ -0,0 +1,166 @@
+package mosaic
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+)
+
+// A BlendMode selects how the source image is combined with the mosaic
+// when it is overlaid.
+type BlendMode int
+
+// Blend modes behave as in the W3C compositing specification.
+const (
+	BlendNormal BlendMode = iota
+	BlendMultiply
+	BlendSoftLight
+)
+
+var blendModeNames = map[BlendMode]string{
+	BlendNormal:    "normal",
+	BlendMultiply:  "multiply",
+	BlendSoftLight: "soft-light",
+}
+
+// ParseBlendMode returns the BlendMode with the given name, as printed by
+// BlendMode.String. The empty string selects BlendNormal.
+func ParseBlendMode(name string) (BlendMode, error) {
+	if name == "" {
+		return BlendNormal, nil
+	}
+	for m, n := range blendModeNames {
+		if n == name {
+			return m, nil
+		}
+	}
+	return 0, fmt.Errorf("mosaic: unknown blend mode %q", name)
+}
+
+func (m BlendMode) String() string {
+	if name, ok := blendModeNames[m]; ok {
+		return name
+	}
+	return fmt.Sprintf("BlendMode(%d)", int(m))
+}
+
+// overlay blends a source image, stretched over rect, onto the mosaic's
+// pixels as they are read.
+type overlay struct {
+	source  *image.RGBA
+	rect    image.Rectangle
+	opacity float64
+	mode    BlendMode
+}
+
+func newOverlay(source image.Image, rect image.Rectangle, opacity float64, mode BlendMode) *overlay {
+	if opacity > 1 {
+		opacity = 1
+	}
+	bounds := source.Bounds()
+	src := image.NewRGBA(bounds)
+	draw.Draw(src, bounds, source, bounds.Min, draw.Src)
+	return &overlay{
+		source:  src,
+		rect:    rect,
+		opacity: opacity,
+		mode:    mode,
+	}
+}
+
+// blend combines base, the mosaic's color at (x, y), with the source.
+func (o *overlay) blend(base color.Color, x, y int) color.Color {
+	if !image.Pt(x, y).In(o.rect) {
+		return base
+	}
+	br, bg, bb, _ := base.RGBA()
+	out := o.mix([3]float64{float64(br) / 0xffff, float64(bg) / 0xffff, float64(bb) / 0xffff}, x, y)
+	return sampleColor(out[0]*0xffff, out[1]*0xffff, out[2]*0xffff)
+}
+
+// draw blends the source over the pixels of dst within r, leaving them
+// opaque as blend does.
+func (o *overlay) draw(dst *image.RGBA, r image.Rectangle) {
+	r = r.Intersect(o.rect)
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		for x := r.Min.X; x < r.Max.X; x++ {
+			p := dst.Pix[dst.PixOffset(x, y):]
+			out := o.mix([3]float64{float64(p[0]) / 0xff, float64(p[1]) / 0xff, float64(p[2]) / 0xff}, x, y)
+			for i, v := range out {
+				p[i] = uint8(clamp16(v*0xffff) >> 8)
+			}
+			p[3] = 0xff
+		}
+	}
+}
+
+// mix blends the source into the base channels b of pixel (x, y), all in
+// [0, 1].
+func (o *overlay) mix(b [3]float64, x, y int) [3]float64 {
+	s := o.sample(x, y)
+	var out [3]float64
+	for i := range b {
+		out[i] = b[i] + (blendChannel(o.mode, b[i], s[i])-b[i])*o.opacity
+	}
+	return out
+}
+
+// sample bilinearly interpolates the source at the point corresponding
+// to mosaic pixel (x, y), returning channels in [0, 1].
+func (o *overlay) sample(x, y int) [3]float64 {
+	bounds := o.source.Bounds()
+	fx := (float64(x-o.rect.Min.X)+0.5)*float64(bounds.Dx())/float64(o.rect.Dx()) - 0.5
+	fy := (float64(y-o.rect.Min.Y)+0.5)*float64(bounds.Dy())/float64(o.rect.Dy()) - 0.5
+	x0, y0 := math.Floor(fx), math.Floor(fy)
+	wx, wy := fx-x0, fy-y0
+
+	var out [3]float64
+	for _, corner := range [4]struct{ dx, dy, w float64 }{
+		{0, 0, (1 - wx) * (1 - wy)},
+		{1, 0, wx * (1 - wy)},
+		{0, 1, (1 - wx) * wy},
+		{1, 1, wx * wy},
+	} {
+		if corner.w == 0 {
+			continue
+		}
+		sx := clampInt(int(x0+corner.dx), 0, bounds.Dx()-1) + bounds.Min.X
+		sy := clampInt(int(y0+corner.dy), 0, bounds.Dy()-1) + bounds.Min.Y
+		c := o.source.RGBAAt(sx, sy)
+		out[0] += corner.w * float64(c.R) / 0xff
+		out[1] += corner.w * float64(c.G) / 0xff
+		out[2] += corner.w * float64(c.B) / 0xff
+	}
+	return out
+}
+
+// blendChannel applies a blend mode to one channel of the base b and
+// source s, following the W3C compositing specification.
+func blendChannel(mode BlendMode, b, s float64) float64 {
+	switch mode {
+	case BlendMultiply:
+		return b * s
+	case BlendSoftLight:
+		if s <= 0.5 {
+			return b - (1-2*s)*b*(1-b)
+		}
+		d := math.Sqrt(b)
+		if b <= 0.25 {
+			d = ((16*b-12)*b + 4) * b
+		}
+		return b + (2*s-1)*(d-b)
+	}
+	return s
+}
+
+func clampInt(v, lo, hi int) int {
+	if v < lo {
+		return lo
+	}
+	if v > hi {
+		return hi
+	}
+	return v
+}
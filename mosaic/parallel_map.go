@@ -0,0 +1,29 @@
+package mosaic
+
+import (
+	"context"
+	"sync"
+)
+
+// parallelMap calls f(i) for each i below n concurrently and waits for
+// them all. Calls not yet started when ctx is done are skipped.
+func parallelMap(ctx context.Context, n int, f func(i int)) {
+	var wg sync.WaitGroup
+	wg.Add(n)
+	for i := 0; i < n; i++ {
+		go func(i int) {
+			if ctx.Err() == nil {
+				f(i)
+			}
+			wg.Done()
+		}(i)
+	}
+
+	wg.Wait()
+}
+
+func step(start, step int, inner func(int)) func(int) {
+	return func(i int) {
+		inner(start + i*step)
+	}
+}
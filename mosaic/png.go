@@ -0,0 +1,227 @@
+package mosaic
+
+import (
+	"bufio"
+	"compress/zlib"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"image"
+	"image/draw"
+	"io"
+)
+
+// bandHeight is the number of rows EncodePNG renders at once.
+const bandHeight = 64
+
+// EncodePNG writes img to w as an 8-bit PNG. Unlike image/png, it renders
+// img a band of rows at a time, using Mosaic.DrawTo when img is a Mosaic,
+// so memory use depends on the width of the image but not its height.
+func EncodePNG(w io.Writer, img image.Image) error {
+	bounds := img.Bounds()
+	if bounds.Empty() {
+		return errors.New("mosaic: cannot encode an empty image")
+	}
+	var (
+		o, ok = img.(interface {
+			Opaque() bool
+		})
+		opaque = ok && o.Opaque()
+		bpp    = 4
+		pw     = &pngWriter{w: w}
+	)
+	if opaque {
+		bpp = 3
+	}
+
+	_, pw.err = io.WriteString(w, "\x89PNG\r\n\x1a\n")
+	var header [13]byte
+	binary.BigEndian.PutUint32(header[0:], uint32(bounds.Dx()))
+	binary.BigEndian.PutUint32(header[4:], uint32(bounds.Dy()))
+	header[8] = 8 // bit depth
+	header[9] = 6 // truecolor with alpha
+	if opaque {
+		header[9] = 2 // truecolor
+	}
+	pw.chunk("IHDR", header[:])
+
+	// Image data is split into IDAT chunks as bufio flushes it.
+	buf := bufio.NewWriterSize(idatWriter{pw}, 1<<15)
+	zw, err := zlib.NewWriterLevel(buf, zlib.BestSpeed)
+	if err != nil {
+		return err
+	}
+	var (
+		band        = image.NewRGBA(image.Rect(bounds.Min.X, bounds.Min.Y, bounds.Max.X, bounds.Min.Y+bandHeight))
+		rows        = newPNGRows(bounds.Dx(), bpp)
+		m, isMosaic = img.(Mosaic)
+	)
+	for y0 := bounds.Min.Y; y0 < bounds.Max.Y && pw.err == nil; y0 += bandHeight {
+		r := image.Rect(bounds.Min.X, y0, bounds.Max.X, min(y0+bandHeight, bounds.Max.Y))
+		band.Rect = r
+		if isMosaic {
+			m.DrawTo(band, r)
+		} else {
+			draw.Draw(band, r, img, r.Min, draw.Src)
+		}
+		for y := r.Min.Y; y < r.Max.Y; y++ {
+			rows.next(band.Pix[(y-r.Min.Y)*band.Stride:], opaque)
+			if _, err := zw.Write(rows.filter()); err != nil {
+				return err
+			}
+		}
+	}
+	if err := zw.Close(); err != nil {
+		return err
+	}
+	if err := buf.Flush(); err != nil {
+		return err
+	}
+	pw.chunk("IEND", nil)
+	return pw.err
+}
+
+// pngWriter writes PNG chunks, remembering the first error.
+type pngWriter struct {
+	w   io.Writer
+	err error
+}
+
+func (p *pngWriter) chunk(name string, data []byte) {
+	if p.err != nil {
+		return
+	}
+	var header [8]byte
+	binary.BigEndian.PutUint32(header[:4], uint32(len(data)))
+	copy(header[4:], name)
+	crc := crc32.NewIEEE()
+	crc.Write(header[4:])
+	crc.Write(data)
+	var footer [4]byte
+	binary.BigEndian.PutUint32(footer[:], crc.Sum32())
+
+	for _, b := range [][]byte{header[:], data, footer[:]} {
+		if _, p.err = p.w.Write(b); p.err != nil {
+			return
+		}
+	}
+}
+
+type idatWriter struct {
+	*pngWriter
+}
+
+func (w idatWriter) Write(data []byte) (int, error) {
+	w.chunk("IDAT", data)
+	if w.err != nil {
+		return 0, w.err
+	}
+	return len(data), nil
+}
+
+// pngRows holds the current and previous scanlines of an image, each
+// prefixed with a filter type byte, and the current line filtered each
+// possible way.
+type pngRows struct {
+	bpp      int
+	cur, pr  []byte
+	filtered [5][]byte
+}
+
+func newPNGRows(width, bpp int) *pngRows {
+	r := &pngRows{
+		bpp: bpp,
+		cur: make([]byte, 1+width*bpp),
+		pr:  make([]byte, 1+width*bpp),
+	}
+	for i := range r.filtered {
+		r.filtered[i] = make([]byte, 1+width*bpp)
+		r.filtered[i][0] = byte(i)
+	}
+	return r
+}
+
+// next converts a row of premultiplied RGBA pixels to the current line.
+func (r *pngRows) next(pix []byte, opaque bool) {
+	r.cur, r.pr = r.pr, r.cur
+	line := r.cur[1:]
+	for x := 0; x < len(line)/r.bpp; x++ {
+		p := pix[x*4 : x*4+4]
+		out := line[x*r.bpp:]
+		if opaque {
+			out[0], out[1], out[2] = p[0], p[1], p[2]
+			continue
+		}
+		a := uint32(p[3])
+		if a == 0 || a == 0xff {
+			copy(out, p)
+			continue
+		}
+		// Unpremultiply at 16 bits, as color.NRGBAModel does, so that
+		// the result matches image/png.
+		for i := 0; i < 3; i++ {
+			out[i] = uint8(uint32(p[i]) * 0x101 * 0xffff / (a * 0x101) >> 8)
+		}
+		out[3] = p[3]
+	}
+}
+
+// filter picks the filter for the current line which gives the smallest
+// sum of absolute differences, as image/png does, and returns the line
+// filtered with it.
+func (r *pngRows) filter() []byte {
+	var (
+		cur, pr = r.cur[1:], r.pr[1:]
+		bpp     = r.bpp
+		f       = &r.filtered
+		sums    [5]int
+	)
+	for i, c := range cur {
+		var left, upLeft byte
+		if i >= bpp {
+			left, upLeft = cur[i-bpp], pr[i-bpp]
+		}
+		up := pr[i]
+		f[0][i+1] = c
+		f[1][i+1] = c - left
+		f[2][i+1] = c - up
+		f[3][i+1] = c - byte((int(left)+int(up))/2)
+		f[4][i+1] = c - paeth(left, up, upLeft)
+		for n := range sums {
+			sums[n] += abs8(f[n][i+1])
+		}
+	}
+	best := 0
+	for n := range sums {
+		if sums[n] < sums[best] {
+			best = n
+		}
+	}
+	return f[best]
+}
+
+func paeth(a, b, c byte) byte {
+	p := int(a) + int(b) - int(c)
+	pa, pb, pc := abs(p-int(a)), abs(p-int(b)), abs(p-int(c))
+	switch {
+	case pa <= pb && pa <= pc:
+		return a
+	case pb <= pc:
+		return b
+	}
+	return c
+}
+
+func abs8(b byte) int {
+	if b < 128 {
+		return int(b)
+	}
+	return 256 - int(b)
+}
+
+func abs(x int) int {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
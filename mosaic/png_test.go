@@ -0,0 +1,110 @@
+package mosaic
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/color"
+	"image/png"
+	"math/rand"
+	"testing"
+)
+
+// checkEncodePNG encodes img with both EncodePNG and image/png and checks
+// that they decode to the same pixels.
+func checkEncodePNG(t *testing.T, img image.Image) {
+	t.Helper()
+	var got, want bytes.Buffer
+	if err := EncodePNG(&got, img); err != nil {
+		t.Fatal(err)
+	}
+	if err := png.Encode(&want, img); err != nil {
+		t.Fatal(err)
+	}
+	gotImg, err := png.Decode(&got)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantImg, err := png.Decode(&want)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	bounds := img.Bounds()
+	if gotImg.Bounds().Size() != bounds.Size() {
+		t.Fatalf("encoded a %v image, want %v", gotImg.Bounds().Size(), bounds.Size())
+	}
+	for y := 0; y < bounds.Dy(); y++ {
+		for x := 0; x < bounds.Dx(); x++ {
+			g := color.NRGBAModel.Convert(gotImg.At(gotImg.Bounds().Min.X+x, gotImg.Bounds().Min.Y+y))
+			w := color.NRGBAModel.Convert(wantImg.At(wantImg.Bounds().Min.X+x, wantImg.Bounds().Min.Y+y))
+			if g != w {
+				t.Fatalf("pixel (%d, %d) is %v, want %v", x, y, g, w)
+			}
+		}
+	}
+}
+
+// noise returns an RGBA image of random premultiplied pixels, with
+// alpha ranging over every value.
+func noise(r image.Rectangle, seed int64) *image.RGBA {
+	rnd := rand.New(rand.NewSource(seed))
+	img := image.NewRGBA(r)
+	for i := 0; i < len(img.Pix); i += 4 {
+		a := uint8(rnd.Intn(256))
+		for j := 0; j < 3; j++ {
+			img.Pix[i+j] = uint8(rnd.Intn(int(a) + 1))
+		}
+		img.Pix[i+3] = a
+	}
+	return img
+}
+
+// opaqueNoise is noise with every pixel made opaque.
+func opaqueNoise(r image.Rectangle, seed int64) *image.RGBA {
+	img := noise(r, seed)
+	for i := 3; i < len(img.Pix); i += 4 {
+		img.Pix[i] = 0xff
+	}
+	return img
+}
+
+func TestEncodePNGPartialAlpha(t *testing.T) {
+	// The height is not a multiple of bandHeight, and the origin is not
+	// zero.
+	checkEncodePNG(t, noise(image.Rect(3, 5, 3+37, 5+2*bandHeight+9), 1))
+}
+
+func TestEncodePNGOpaque(t *testing.T) {
+	checkEncodePNG(t, opaqueNoise(image.Rect(0, 0, 20, bandHeight+1), 2))
+}
+
+func TestEncodePNGMosaic(t *testing.T) {
+	var images []image.Image
+	for i := 0; i < 6; i++ {
+		images = append(images, opaqueNoise(image.Rect(0, 0, 12, 12), int64(10+i)))
+	}
+	tiler, err := NewTiler(images, TilerOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	in := opaqueNoise(image.Rect(0, 0, 70, 50), 3)
+	m, err := Render(context.Background(), in, tiler, Options{
+		SampleSize:   10,
+		TileSize:     12,
+		Grout:        2,
+		Border:       3,
+		CornerRadius: 4,
+		Background:   color.RGBA{0x33, 0x66, 0x99, 0xff},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !m.Opaque() {
+		t.Fatal("mosaic with an opaque background is not opaque")
+	}
+	if m.Bounds().Dy()%bandHeight == 0 {
+		t.Fatalf("mosaic height %d is a multiple of bandHeight", m.Bounds().Dy())
+	}
+	checkEncodePNG(t, m)
+}
@@ -0,0 +1,48 @@
+package mosaic
+
+import "sync"
+
+// Progress reports how far LoadTiles or Render has got with one stage of
+// its work.
+type Progress struct {
+	// Stage is "pages" for pages of tile locations fetched from a
+	// TileSource, "tiles" for tiles loaded and "cells" for cells of the
+	// output matched to tiles.
+	Stage string
+	Done  int
+	// Failed counts tiles which could not be loaded.
+	Failed int
+	// Total is the number Done is expected to reach, or 0 if unknown.
+	Total int
+}
+
+// A ProgressFunc receives Progress as work is done. Calls are never
+// concurrent, but may come from any goroutine.
+type ProgressFunc func(Progress)
+
+// progress counts the work done in one stage, reporting each change to
+// fn. A nil progress, or one with a nil fn, ignores all updates.
+type progress struct {
+	mu sync.Mutex
+	fn ProgressFunc
+	p  Progress
+}
+
+func newProgress(fn ProgressFunc, stage string, total int) *progress {
+	p := &progress{fn: fn, p: Progress{Stage: stage, Total: total}}
+	if fn != nil {
+		fn(p.p)
+	}
+	return p
+}
+
+func (p *progress) add(done, failed int) {
+	if p == nil || p.fn == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.p.Done += done
+	p.p.Failed += failed
+	p.fn(p.p)
+}
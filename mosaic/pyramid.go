@@ -0,0 +1,351 @@
+package mosaic
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"image/draw"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// A PyramidScheme selects how a Pyramid numbers and names its tiles.
+type PyramidScheme int
+
+const (
+	// DeepZoom pyramids follow the Deep Zoom Image format read by
+	// OpenSeadragon: level 0 is a single pixel and each level doubles
+	// the size of the last, up to the full image.
+	DeepZoom PyramidScheme = iota
+	// XYZ pyramids number levels from a single tile holding the whole
+	// image, as slippy maps do. Tiles are always TileSize square, with
+	// any area beyond the image left transparent.
+	XYZ
+)
+
+var pyramidSchemeNames = map[PyramidScheme]string{
+	DeepZoom: "dzi",
+	XYZ:      "xyz",
+}
+
+// ParsePyramidScheme returns the PyramidScheme with the given name, as
+// printed by PyramidScheme.String. The empty string selects DeepZoom.
+func ParsePyramidScheme(name string) (PyramidScheme, error) {
+	if name == "" {
+		return DeepZoom, nil
+	}
+	for s, n := range pyramidSchemeNames {
+		if n == name {
+			return s, nil
+		}
+	}
+	return 0, fmt.Errorf("mosaic: unknown pyramid scheme %q", name)
+}
+
+func (s PyramidScheme) String() string {
+	if name, ok := pyramidSchemeNames[s]; ok {
+		return name
+	}
+	return fmt.Sprintf("PyramidScheme(%d)", int(s))
+}
+
+// PyramidOptions control how a Pyramid is cut into tiles.
+type PyramidOptions struct {
+	Scheme PyramidScheme
+	// TileSize is the width of each tile, not counting overlap. Zero
+	// means 256.
+	TileSize int
+	// Overlap is the number of pixels DeepZoom tiles share with their
+	// neighbours on each side.
+	Overlap int
+	// Format is the file format of the tiles, "png" (the default) or
+	// "jpg".
+	Format string
+}
+
+// ErrNoTile is returned for tiles outside a Pyramid.
+var ErrNoTile = errors.New("mosaic: no such tile")
+
+// maxSupersample is the largest number of samples per axis averaged for
+// each pixel of a reduced level. Levels reduced further than this are
+// sampled sparsely rather than drawn in full.
+const maxSupersample = 4
+
+// A Pyramid cuts an image into tiles at a series of scales, so that a
+// viewer can zoom from the whole image down to single pixels while only
+// loading the tiles in view. Tiles are rendered when asked for.
+type Pyramid struct {
+	image  image.Image
+	opts   PyramidOptions
+	levels int
+}
+
+// NewPyramid returns a Pyramid over img.
+func NewPyramid(img image.Image, opts PyramidOptions) (*Pyramid, error) {
+	if img.Bounds().Empty() {
+		return nil, errors.New("mosaic: cannot tile an empty image")
+	}
+	if opts.TileSize < 0 || opts.Overlap < 0 {
+		return nil, errors.New("mosaic: TileSize and Overlap must not be negative")
+	}
+	if opts.TileSize == 0 {
+		opts.TileSize = 256
+	}
+	if opts.Scheme == XYZ {
+		opts.Overlap = 0
+	}
+	switch opts.Format {
+	case "":
+		opts.Format = "png"
+	case "png", "jpg":
+	default:
+		return nil, fmt.Errorf("mosaic: unknown tile format %q", opts.Format)
+	}
+
+	p := &Pyramid{image: img, opts: opts}
+	size := img.Bounds().Size()
+	largest := max(size.X, size.Y)
+	if opts.Scheme == XYZ {
+		largest = ceilDiv(largest, opts.TileSize)
+	}
+	for 1<<uint(p.levels) < largest {
+		p.levels++
+	}
+	p.levels++
+	return p, nil
+}
+
+// Levels returns the number of levels in p. The last is the full size
+// image.
+func (p *Pyramid) Levels() int {
+	return p.levels
+}
+
+// scale returns how many pixels of the full image span one pixel of
+// level.
+func (p *Pyramid) scale(level int) int {
+	return 1 << uint(p.levels-1-level)
+}
+
+// Size returns the size of the image at level.
+func (p *Pyramid) Size(level int) image.Point {
+	var (
+		size  = p.image.Bounds().Size()
+		scale = p.scale(level)
+	)
+	return image.Pt(ceilDiv(size.X, scale), ceilDiv(size.Y, scale))
+}
+
+// Tiles returns the number of columns and rows of tiles at level.
+func (p *Pyramid) Tiles(level int) (cols, rows int) {
+	size := p.Size(level)
+	return ceilDiv(size.X, p.opts.TileSize), ceilDiv(size.Y, p.opts.TileSize)
+}
+
+// tileRect returns the part of level covered by a tile.
+func (p *Pyramid) tileRect(level, col, row int) (image.Rectangle, error) {
+	if level < 0 || level >= p.levels {
+		return image.Rectangle{}, ErrNoTile
+	}
+	cols, rows := p.Tiles(level)
+	if col < 0 || row < 0 || col >= cols || row >= rows {
+		return image.Rectangle{}, ErrNoTile
+	}
+	ts := p.opts.TileSize
+	r := image.Rect(col*ts, row*ts, (col+1)*ts, (row+1)*ts)
+	if p.opts.Scheme == XYZ {
+		return r, nil
+	}
+	return r.Inset(-p.opts.Overlap).Intersect(image.Rectangle{Max: p.Size(level)}), nil
+}
+
+// Tile renders one tile. Columns and rows are numbered from the top left.
+func (p *Pyramid) Tile(level, col, row int) (image.Image, error) {
+	r, err := p.tileRect(level, col, row)
+	if err != nil {
+		return nil, err
+	}
+	var (
+		bounds = p.image.Bounds()
+		scale  = p.scale(level)
+		region = image.Rectangle{Min: r.Min.Mul(scale), Max: r.Max.Mul(scale)}.Add(bounds.Min).Intersect(bounds)
+		src    = p.image
+		out    = image.NewRGBA(image.Rectangle{Max: r.Size()})
+	)
+	if scale <= maxSupersample {
+		src = drawRGBA(p.image, region)
+		if scale == 1 {
+			draw.Draw(out, region.Sub(region.Min), src, region.Min, draw.Src)
+			return out, nil
+		}
+	}
+
+	// Each pixel averages n x n evenly spaced samples of the scale x
+	// scale block of the image it covers.
+	var (
+		n    = min(scale, maxSupersample)
+		step = scale / n
+	)
+	for y := 0; y < r.Dy(); y++ {
+		for x := 0; x < r.Dx(); x++ {
+			var sum [4]uint32
+			count := uint32(0)
+			for j := 0; j < n; j++ {
+				for i := 0; i < n; i++ {
+					sp := image.Pt((r.Min.X+x)*scale+i*step+step/2, (r.Min.Y+y)*scale+j*step+step/2).Add(bounds.Min)
+					if !sp.In(region) {
+						continue
+					}
+					c := pixel(src, sp.X, sp.Y)
+					for k := range sum {
+						sum[k] += c[k]
+					}
+					count++
+				}
+			}
+			if count == 0 {
+				continue
+			}
+			px := out.Pix[out.PixOffset(x, y):]
+			for k := range sum {
+				px[k] = uint8(sum[k] / count)
+			}
+		}
+	}
+	return out, nil
+}
+
+// EncodeTile renders one tile and writes it to w in the pyramid's
+// format.
+func (p *Pyramid) EncodeTile(w io.Writer, level, col, row int) error {
+	tile, err := p.Tile(level, col, row)
+	if err != nil {
+		return err
+	}
+	if p.opts.Format == "jpg" {
+		return jpeg.Encode(w, tile, &jpeg.Options{Quality: 90})
+	}
+	return png.Encode(w, tile)
+}
+
+// TilePath returns the path of a tile within a pyramid written by Write
+// with the given name.
+func (p *Pyramid) TilePath(name string, level, col, row int) string {
+	if p.opts.Scheme == XYZ {
+		return fmt.Sprintf("%s/%d/%d/%d.%s", name, level, col, row, p.opts.Format)
+	}
+	return fmt.Sprintf("%s_files/%d/%d_%d.%s", name, level, col, row, p.opts.Format)
+}
+
+// WriteDescriptor writes the .dzi file describing a DeepZoom pyramid.
+// XYZ pyramids have no descriptor.
+func (p *Pyramid) WriteDescriptor(w io.Writer) error {
+	if p.opts.Scheme != DeepZoom {
+		return errors.New("mosaic: only DeepZoom pyramids have a descriptor")
+	}
+	size := p.image.Bounds().Size()
+	_, err := fmt.Fprintf(w, `<?xml version="1.0" encoding="UTF-8"?>
+<Image xmlns="http://schemas.microsoft.com/deepzoom/2008" TileSize="%d" Overlap="%d" Format="%s">
+  <Size Width="%d" Height="%d"/>
+</Image>
+`, p.opts.TileSize, p.opts.Overlap, p.opts.Format, size.X, size.Y)
+	return err
+}
+
+// A FileCreator stores the files of a pyramid. Each file is complete
+// once the next is created. *zip.Writer is a FileCreator.
+type FileCreator interface {
+	Create(name string) (io.Writer, error)
+}
+
+// Write renders every tile of p into dst. DeepZoom pyramids are written
+// as name.dzi and a name_files directory, and XYZ pyramids as a name
+// directory of level/column/row files.
+func (p *Pyramid) Write(dst FileCreator, name string) error {
+	if p.opts.Scheme == DeepZoom {
+		w, err := dst.Create(name + ".dzi")
+		if err != nil {
+			return err
+		}
+		if err := p.WriteDescriptor(w); err != nil {
+			return err
+		}
+	}
+	for level := 0; level < p.levels; level++ {
+		cols, rows := p.Tiles(level)
+		for row := 0; row < rows; row++ {
+			for col := 0; col < cols; col++ {
+				w, err := dst.Create(p.TilePath(name, level, col, row))
+				if err != nil {
+					return err
+				}
+				if err := p.EncodeTile(w, level, col, row); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// A DirWriter is a FileCreator which writes files under a directory.
+type DirWriter struct {
+	dir  string
+	file *os.File
+}
+
+// NewDirWriter returns a DirWriter which writes under dir.
+func NewDirWriter(dir string) *DirWriter {
+	return &DirWriter{dir: dir}
+}
+
+// Create closes the previous file and creates name, with any missing
+// parent directories.
+func (d *DirWriter) Create(name string) (io.Writer, error) {
+	if err := d.Close(); err != nil {
+		return nil, err
+	}
+	path := filepath.Join(d.dir, filepath.FromSlash(name))
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	d.file = f
+	return f, nil
+}
+
+// Close closes the last file created.
+func (d *DirWriter) Close() error {
+	if d.file == nil {
+		return nil
+	}
+	err := d.file.Close()
+	d.file = nil
+	return err
+}
+
+// drawRGBA copies the part of img within r.
+func drawRGBA(img image.Image, r image.Rectangle) *image.RGBA {
+	if m, ok := img.(Mosaic); ok {
+		return m.SubImage(r).(*image.RGBA)
+	}
+	out := image.NewRGBA(r)
+	draw.Draw(out, r, img, r.Min, draw.Src)
+	return out
+}
+
+// pixel returns the 8-bit premultiplied components of img at (x, y).
+func pixel(img image.Image, x, y int) [4]uint32 {
+	if rgba, ok := img.(*image.RGBA); ok {
+		c := rgba.RGBAAt(x, y)
+		return [4]uint32{uint32(c.R), uint32(c.G), uint32(c.B), uint32(c.A)}
+	}
+	r, g, b, a := img.At(x, y).RGBA()
+	return [4]uint32{r >> 8, g >> 8, b >> 8, a >> 8}
+}
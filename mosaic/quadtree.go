@@ -0,0 +1,147 @@
+package mosaic
+
+import (
+	"context"
+	"image"
+	"image/draw"
+	"math"
+	"sync"
+)
+
+const defaultDetailThreshold = 16
+
+// renderQuadtree covers in, already cropped to a numTilesX x numTilesY
+// grid, with tiles which shrink where the input is detailed.
+func renderQuadtree(ctx context.Context, in image.Image, tiler *Tiler, numTilesX, numTilesY int, opts Options) (Mosaic, error) {
+	var (
+		minSize   = opts.MinTileSize
+		threshold = opts.DetailThreshold
+		bounds    = in.Bounds()
+		src       = image.NewRGBA(bounds)
+		grout     = opts.Grout
+		pitch     = opts.TileSize + grout
+		scale     = float64(opts.SampleSize) / float64(pitch)
+	)
+	if minSize <= 0 {
+		minSize = opts.TileSize / 4
+	}
+	minSize = max(minSize, 1)
+	if threshold <= 0 {
+		threshold = defaultDetailThreshold
+	}
+	draw.Draw(src, bounds, in, bounds.Min, draw.Src)
+
+	region := func(r image.Rectangle) image.Rectangle {
+		return sourceRegion(bounds, r, scale)
+	}
+
+	var blocks []image.Rectangle
+	var split func(r image.Rectangle)
+	split = func(r image.Rectangle) {
+		// Halves are separated by grout like any other tiles.
+		inner := r.Size().Sub(image.Pt(grout, grout))
+		lo := inner.Div(2)
+		hi := inner.Sub(lo)
+		if lo.X < minSize || lo.Y < minSize || colorDeviation(src, region(r)) <= threshold {
+			blocks = append(blocks, r)
+			return
+		}
+		split(image.Rectangle{Min: r.Min, Max: r.Min.Add(lo)})
+		split(image.Rect(r.Max.X-hi.X, r.Min.Y, r.Max.X, r.Min.Y+lo.Y))
+		split(image.Rect(r.Min.X, r.Max.Y-hi.Y, r.Min.X+lo.X, r.Max.Y))
+		split(image.Rectangle{Min: r.Max.Sub(hi), Max: r.Max})
+	}
+	size := opts.TileSize
+	for j := 0; j < numTilesY; j++ {
+		for i := 0; i < numTilesX; i++ {
+			split(image.Rect(i*pitch, j*pitch, i*pitch+size, j*pitch+size))
+		}
+	}
+
+	var (
+		tiles   = make([]placedTile, len(blocks))
+		resized = newResizeCache()
+		matched = newProgress(opts.Progress, "cells", len(blocks))
+	)
+	parallelMap(ctx, len(blocks), func(b int) {
+		samples := tiler.samples(crop(src, region(blocks[b])))
+		choice := tiler.nearest(tiler.pointOf(samples), nil)
+		tiles[b] = placedTile{
+			rect:  blocks[b],
+			image: finishTile(resized.get(tiler, choice, blocks[b].Size()), samples, opts),
+		}
+		matched.add(1, 0)
+	})
+	if err := ctx.Err(); err != nil {
+		return Mosaic{}, err
+	}
+	return finishMosaic(in, image.Rect(0, 0, numTilesX*pitch-grout, numTilesY*pitch-grout), tiles, opts), nil
+}
+
+// colorDeviation returns the standard deviation of the 8-bit color
+// channels of src within r, averaged over the channels.
+func colorDeviation(src *image.RGBA, r image.Rectangle) float64 {
+	var sum, sumSq [3]float64
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		for x := r.Min.X; x < r.Max.X; x++ {
+			c := src.RGBAAt(x, y)
+			for i, v := range [3]uint8{c.R, c.G, c.B} {
+				sum[i] += float64(v)
+				sumSq[i] += float64(v) * float64(v)
+			}
+		}
+	}
+	n := float64(r.Dx() * r.Dy())
+	if n == 0 {
+		return 0
+	}
+	var deviation float64
+	for i := range sum {
+		mean := sum[i] / n
+		deviation += math.Sqrt(math.Max(sumSq[i]/n-mean*mean, 0))
+	}
+	return deviation / 3
+}
+
+// resizeCache shares resized copies of tiles between placements of the
+// same size. Each copy is made once, outside the lock, so that workers
+// resizing different tiles do not wait on each other.
+type resizeCache struct {
+	mu      sync.Mutex
+	entries map[resizeKey]*resizeEntry
+}
+
+type resizeKey struct {
+	tile int
+	size image.Point
+}
+
+type resizeEntry struct {
+	once  sync.Once
+	image image.Image
+}
+
+func newResizeCache() *resizeCache {
+	return &resizeCache{entries: make(map[resizeKey]*resizeEntry)}
+}
+
+func (c *resizeCache) get(tiler *Tiler, tile int, size image.Point) image.Image {
+	img := tiler.images[tile].image
+	if img.Bounds().Size() == size {
+		return img
+	}
+
+	key := resizeKey{tile: tile, size: size}
+	c.mu.Lock()
+	entry, ok := c.entries[key]
+	if !ok {
+		entry = new(resizeEntry)
+		c.entries[key] = entry
+	}
+	c.mu.Unlock()
+
+	entry.once.Do(func() {
+		entry.image = resize(img, image.Rectangle{Max: size})
+	})
+	return entry.image
+}
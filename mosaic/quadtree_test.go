@@ -0,0 +1,41 @@
+package mosaic
+
+import (
+	"image"
+	"sync"
+	"testing"
+)
+
+func TestResizeCacheSharesCopies(t *testing.T) {
+	var (
+		tiler = grayTiler(t, 8)
+		cache = newResizeCache()
+		sizes = []image.Point{{2, 2}, {5, 5}, {9, 9}}
+		got   = make([][]image.Image, 16)
+		wg    sync.WaitGroup
+	)
+	for w := range got {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for tile := range tiler.images {
+				for _, size := range sizes {
+					got[w] = append(got[w], cache.get(tiler, tile, size))
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	for i, img := range got[0] {
+		size := sizes[i%len(sizes)]
+		if img.Bounds().Size() != size {
+			t.Fatalf("resized to %v, want %v", img.Bounds().Size(), size)
+		}
+		for w := range got {
+			if got[w][i] != img {
+				t.Fatalf("worker %d got a different copy of tile %d at %v", w, i/len(sizes), size)
+			}
+		}
+	}
+}
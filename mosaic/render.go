@@ -0,0 +1,262 @@
+// Package mosaic builds photomosaics: it loads a set of tile images,
+// matches each region of an input image to the closest tile and renders
+// the result as an image.Image.
+package mosaic
+
+import (
+	"context"
+	"errors"
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+)
+
+// Options control how Render divides up its input.
+type Options struct {
+	// SampleSize is the width of the square region of the input covered
+	// by each tile.
+	SampleSize int
+	// TileSize is the width of each tile in the output.
+	TileSize int
+
+	// MaxUses limits how many cells each tile may fill. Zero means no
+	// limit.
+	MaxUses int
+	// MinRepeatDistance is the minimum distance, in cells, between two
+	// uses of the same tile. Zero or one allows identical neighbours.
+	// Each cell checks the (2d-1)² cells around it, so large distances
+	// are slow.
+	MinRepeatDistance int
+	// Seed fixes the order cells are visited in when MaxUses or
+	// MinRepeatDistance is set, so that results are reproducible.
+	Seed int64
+	// Assignment selects between per-cell and globally optimal matching.
+	Assignment Assignment
+	// Dither diffuses the difference between each cell and its tile into
+	// the cells not yet matched. It is ignored by Optimal assignment.
+	Dither Dither
+
+	// Tint corrects each tile toward the average color of its cell, from
+	// 0 for the raw tile to 1 for the strongest correction TintMode
+	// allows.
+	Tint     float64
+	TintMode TintMode
+
+	// Overlay is the opacity, from 0 to 1, at which the cropped input is
+	// blended over the finished mosaic using OverlayMode.
+	Overlay     float64
+	OverlayMode BlendMode
+
+	// Layout arranges the tiles. For LayoutQuadtree, TileSize is the
+	// largest tile size, MinTileSize the smallest, and DetailThreshold
+	// the standard deviation of a region's 8-bit color channels above
+	// which it is split. Zero values default to TileSize / 4 and 16.
+	//
+	// LayoutBrick, LayoutHex and LayoutCircles keep TileSize tiles but
+	// position and shape them differently; cells are matched against
+	// the region of the input under each tile.
+	Layout          Layout
+	MinTileSize     int
+	DetailThreshold float64
+	// Grout is the width of the gaps between tiles and Border the width
+	// of the margin around them; both are added to the size of the
+	// output. CornerRadius rounds the corners of rectangular tiles.
+	Grout        int
+	Border       int
+	CornerRadius int
+	// Background fills the grout, the border and any other part of the
+	// output not covered by a tile. Nil means black.
+	Background color.Color
+
+	// Progress, if not nil, is told as cells are matched.
+	Progress ProgressFunc
+}
+
+// Render replaces each SampleSize square of in with the tile from tiler
+// which best matches it. Any remainder at the right and bottom edges of
+// in is cropped. If ctx is done before every cell is matched, Render
+// stops and returns its error.
+func Render(ctx context.Context, in image.Image, tiler *Tiler, opts Options) (Mosaic, error) {
+	if opts.SampleSize <= 0 || opts.TileSize <= 0 {
+		return Mosaic{}, errors.New("mosaic: SampleSize and TileSize must be positive")
+	}
+	if opts.Layout == LayoutHex && opts.TileSize < 4 {
+		return Mosaic{}, errors.New("mosaic: the hex layout needs a TileSize of at least 4")
+	}
+	if opts.Grout < 0 || opts.Border < 0 || opts.CornerRadius < 0 {
+		return Mosaic{}, errors.New("mosaic: Grout, Border and CornerRadius must not be negative")
+	}
+
+	in = cropToMultiple(in, opts.SampleSize)
+	bounds := in.Bounds().Canon()
+
+	numTilesX := bounds.Dx() / opts.SampleSize
+	numTilesY := bounds.Dy() / opts.SampleSize
+	if numTilesX == 0 || numTilesY == 0 {
+		return Mosaic{}, errors.New("mosaic: input is smaller than SampleSize")
+	}
+
+	if opts.Layout == LayoutQuadtree {
+		return renderQuadtree(ctx, in, tiler, numTilesX, numTilesY, opts)
+	}
+	var (
+		pitch = opts.TileSize + opts.Grout
+		cells *cellGrid
+		grid  = newLattice(opts.Layout, numTilesX, numTilesY, opts.TileSize, opts.Grout)
+	)
+	if opts.Layout == LayoutGrid {
+		cells = sampleGrid(ctx, in, tiler, numTilesX, numTilesY)
+	} else {
+		cells = sampleLattice(ctx, in, tiler, grid, float64(opts.SampleSize)/float64(pitch))
+	}
+	if err := ctx.Err(); err != nil {
+		return Mosaic{}, err
+	}
+	cells.matched = newProgress(opts.Progress, "cells", len(cells.points))
+
+	var choices []int
+	switch {
+	case opts.Assignment == Optimal:
+		choices = optimalAssign(ctx, tiler, cells, opts)
+	case opts.Dither != NoDither:
+		choices = ditherAssign(ctx, tiler, cells, opts)
+	case opts.MaxUses > 0 || opts.MinRepeatDistance > 1:
+		choices = constrainedAssign(ctx, tiler, cells, opts)
+	default:
+		choices = greedyAssign(ctx, tiler, cells)
+	}
+	if err := ctx.Err(); err != nil {
+		return Mosaic{}, err
+	}
+
+	tiles := make([]placedTile, 0, len(choices))
+	for j := 0; j < grid.rows; j++ {
+		for i := 0; i < grid.cols; i++ {
+			index := cells.index(i, j)
+			tiles = append(tiles, placedTile{
+				rect:  grid.rect(i, j),
+				image: finishTile(tiler.images[choices[index]].image, cells.samples[index], opts),
+				mask:  grid.mask,
+			})
+		}
+	}
+	return finishMosaic(in, image.Rect(0, 0, numTilesX*pitch-opts.Grout, numTilesY*pitch-opts.Grout), tiles, opts), nil
+}
+
+// sampleGrid describes each SampleSize square of in, downsampling each to
+// one pixel per grid sample.
+func sampleGrid(ctx context.Context, in image.Image, tiler *Tiler, numTilesX, numTilesY int) *cellGrid {
+	grid := tiler.Grid()
+	in = resize(in, image.Rect(0, 0, numTilesX*grid, numTilesY*grid))
+
+	cells := newCellGrid(numTilesX, numTilesY)
+	parallelMap(ctx, numTilesX, func(i int) {
+		parallelMap(ctx, numTilesY, func(j int) {
+			cell := image.Rect(i*grid, j*grid, (i+1)*grid, (j+1)*grid)
+			index := cells.index(i, j)
+			cells.samples[index] = tiler.samples(crop(in, cell))
+			cells.points[index] = tiler.pointOf(cells.samples[index])
+		})
+	})
+	return cells
+}
+
+// sampleLattice describes the region of in under each cell of grid,
+// where scale is the number of input pixels per output pixel.
+func sampleLattice(ctx context.Context, in image.Image, tiler *Tiler, grid lattice, scale float64) *cellGrid {
+	var (
+		bounds = in.Bounds()
+		src    = image.NewRGBA(bounds)
+		cells  = newCellGrid(grid.cols, grid.rows)
+	)
+	draw.Draw(src, bounds, in, bounds.Min, draw.Src)
+	parallelMap(ctx, grid.rows, func(j int) {
+		for i := 0; i < grid.cols; i++ {
+			index := cells.index(i, j)
+			cells.samples[index] = tiler.samples(crop(src, sourceRegion(bounds, grid.rect(i, j), scale)))
+			cells.points[index] = tiler.pointOf(cells.samples[index])
+		}
+	})
+	return cells
+}
+
+// sourceRegion maps an output rectangle to the part of bounds it covers,
+// where scale is the number of input pixels per output pixel. Rectangles
+// outside bounds map to the nearest edge, so the result is never empty.
+func sourceRegion(bounds, r image.Rectangle, scale float64) image.Rectangle {
+	region := image.Rect(
+		int(float64(r.Min.X)*scale),
+		int(float64(r.Min.Y)*scale),
+		int(math.Ceil(float64(r.Max.X)*scale)),
+		int(math.Ceil(float64(r.Max.Y)*scale)),
+	).Add(bounds.Min)
+	region.Min.X = clampInt(region.Min.X, bounds.Min.X, bounds.Max.X-1)
+	region.Min.Y = clampInt(region.Min.Y, bounds.Min.Y, bounds.Max.Y-1)
+	region.Max.X = clampInt(region.Max.X, region.Min.X+1, bounds.Max.X)
+	region.Max.Y = clampInt(region.Max.Y, region.Min.Y+1, bounds.Max.Y)
+	return region
+}
+
+// finishTile applies any tint toward the color of the samples a tile was
+// matched against.
+func finishTile(tile image.Image, samples []float64, opts Options) image.Image {
+	if opts.Tint <= 0 {
+		return tile
+	}
+	return newTinted(tile, averageSamples(samples), opts.TintMode, opts.Tint)
+}
+
+// finishMosaic assembles tiles placed over canvas into a Mosaic, adding
+// any rounded corners and border and overlaying source if requested.
+func finishMosaic(source image.Image, canvas image.Rectangle, tiles []placedTile, opts Options) Mosaic {
+	corners := make(map[image.Point]*image.Alpha)
+	for i := range tiles {
+		t := &tiles[i]
+		if opts.CornerRadius > 0 && t.mask == nil {
+			size := t.rect.Size()
+			if corners[size] == nil {
+				corners[size] = newMask(size, 4, roundedRect(size, opts.CornerRadius))
+			}
+			t.mask = corners[size]
+		}
+		t.rect = t.rect.Add(image.Pt(opts.Border, opts.Border))
+	}
+	canvas = canvas.Add(image.Pt(opts.Border, opts.Border))
+
+	output := newMosaic(canvas.Inset(-opts.Border), tiles)
+	if opts.Background != nil {
+		output.background = opts.Background
+	}
+	if opts.Overlay > 0 {
+		output.overlay = newOverlay(source, canvas, opts.Overlay, opts.OverlayMode)
+	}
+	return output
+}
+
+func cropToMultiple(img image.Image, tileSize int) image.Image {
+	min := img.Bounds().Min
+	width := roundTo(img.Bounds().Dx(), tileSize)
+	height := roundTo(img.Bounds().Dy(), tileSize)
+	return crop(img, image.Rect(min.X, min.Y, width+min.X, height+min.Y))
+}
+
+func roundTo(x, div int) int {
+	return (x / div) * div
+}
+
+type subImage struct {
+	rect image.Rectangle
+	image.Image
+}
+
+func (s subImage) Bounds() image.Rectangle {
+	return s.rect
+}
+
+func crop(img image.Image, rect image.Rectangle) image.Image {
+	return subImage{
+		Image: img,
+		rect:  rect,
+	}
+}
@@ -0,0 +1,16 @@
+package mosaic
+
+import (
+	"context"
+	"image"
+)
+
+// A TileSource enumerates candidate tile images a page at a time. Both
+// methods give up when ctx is done.
+type TileSource interface {
+	// Page returns the locations of the candidates on the given page,
+	// counting from zero. An empty page marks the end of the source.
+	Page(ctx context.Context, page int) ([]string, error)
+	// Load fetches a candidate returned by Page.
+	Load(ctx context.Context, location string) (image.Image, error)
+}
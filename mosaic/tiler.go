@@ -0,0 +1,162 @@
+package mosaic
+
+import (
+	"errors"
+	"fmt"
+	"image"
+)
+
+// ErrNoTiles is returned by NewTiler when it is given no images.
+var ErrNoTiles = errors.New("mosaic: no tiles loaded")
+
+// A Tiler matches regions of an image to the tile which looks most like
+// them. Tiles and regions are both described by a grid of average
+// colors, so that a tile's structure is matched as well as its color.
+type Tiler struct {
+	metric Metric
+	grid   int
+	images []Tile
+	// sources lists the indices in images of each original tile's
+	// variants.
+	sources [][]int
+	index   *kdTree
+}
+
+// TilerOptions configure how a Tiler compares tiles.
+type TilerOptions struct {
+	Metric Metric
+	// Grid is the number of rows and columns of average colors used to
+	// describe each tile. Zero is treated as 1, a single average color.
+	Grid int
+
+	// Rotations adds each tile rotated by 90, 180 and 270 degrees as
+	// extra candidates, and Mirrors adds its horizontal and vertical
+	// mirror images. Together they give eight variants of every tile.
+	// Leave them off for tiles such as faces or text which look wrong
+	// turned around.
+	Rotations bool
+	Mirrors   bool
+}
+
+type Tile struct {
+	samples []float64
+	point   []float64
+	image   image.Image
+	// source is the index of the image this tile is a variant of.
+	source int
+}
+
+func NewTiler(images []image.Image, opts TilerOptions) (*Tiler, error) {
+	if len(images) == 0 {
+		return nil, ErrNoTiles
+	}
+	if opts.Grid <= 0 {
+		opts.Grid = 1
+	}
+	var (
+		tiler  = &Tiler{metric: opts.Metric, grid: opts.Grid}
+		points [][]float64
+	)
+	for i, img := range images {
+		size := img.Bounds().Size()
+		if size.X < opts.Grid || size.Y < opts.Grid {
+			return nil, fmt.Errorf("mosaic: %v tile is smaller than a %dx%d grid", size, opts.Grid, opts.Grid)
+		}
+		var indices []int
+		for _, variant := range variants(img, opts) {
+			samples := tiler.samples(variant)
+			point := tiler.pointOf(samples)
+			indices = append(indices, len(tiler.images))
+			points = append(points, point)
+			tiler.images = append(tiler.images, Tile{
+				image:   variant,
+				samples: samples,
+				point:   point,
+				source:  i,
+			})
+		}
+		tiler.sources = append(tiler.sources, indices)
+	}
+	if opts.Metric.euclidean() {
+		tiler.index = newKDTree(points)
+	}
+	return tiler, nil
+}
+
+// Match returns the tile which looks most like cell.
+func (t *Tiler) Match(cell image.Image) image.Image {
+	return t.images[t.nearest(t.point(cell), nil)].image
+}
+
+// Grid returns the number of rows and columns of samples the Tiler
+// compares, so callers can downsample cells to Grid x Grid pixels.
+func (t *Tiler) Grid() int {
+	return t.grid
+}
+
+// point describes img as the concatenated points of its grid averages.
+func (t *Tiler) point(img image.Image) []float64 {
+	return t.pointOf(t.samples(img))
+}
+
+// samples returns the RGB components of img's grid averages.
+func (t *Tiler) samples(img image.Image) []float64 {
+	samples := make([]float64, 0, t.grid*t.grid*3)
+	for _, c := range gridAverages(img, t.grid) {
+		r, g, b, _ := c.RGBA()
+		samples = append(samples, float64(r), float64(g), float64(b))
+	}
+	return samples
+}
+
+// pointOf converts samples into the Tiler's metric space.
+func (t *Tiler) pointOf(samples []float64) []float64 {
+	point := make([]float64, 0, len(samples))
+	for i := 0; i+3 <= len(samples); i += 3 {
+		c := sampleColor(samples[i], samples[i+1], samples[i+2])
+		point = append(point, t.metric.point(c)...)
+	}
+	return point
+}
+
+// nearest returns the index of the tile closest to q among those for
+// which accept returns true, or -1 if there are none. A nil accept
+// allows every tile.
+func (t *Tiler) nearest(q []float64, accept func(int) bool) int {
+	if t.index != nil {
+		return t.index.nearest(q, accept)
+	}
+
+	var (
+		best        = -1
+		minDistance float64
+	)
+	for i, tile := range t.images {
+		if accept != nil && !accept(i) {
+			continue
+		}
+		d := t.metric.distance(q, tile.point)
+		if best < 0 || d < minDistance {
+			best = i
+			minDistance = d
+		}
+	}
+	return best
+}
+
+// bestVariant returns the variant of a source image closest to q, and its
+// distance from q.
+func (t *Tiler) bestVariant(q []float64, source int) (int, float64) {
+	var (
+		best        = -1
+		minDistance float64
+	)
+	for _, i := range t.sources[source] {
+		d := t.metric.distance(q, t.images[i].point)
+		if best < 0 || d < minDistance {
+			best = i
+			minDistance = d
+		}
+	}
+	return best, minDistance
+}
@@ -0,0 +1,122 @@
+package mosaic
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+)
+
+// A TintMode selects how tiles are corrected toward the color of the
+// cell they fill.
+type TintMode int
+
+const (
+	// TintBlend mixes every pixel of the tile with the cell's color. At
+	// full strength the tile becomes a flat patch of that color.
+	TintBlend TintMode = iota
+	// TintHue shifts the chroma of every pixel toward the cell's color
+	// while keeping the pixel's luminance, so the tile's detail survives
+	// even at full strength.
+	TintHue
+)
+
+var tintModeNames = map[TintMode]string{
+	TintBlend: "blend",
+	TintHue:   "hue",
+}
+
+// ParseTintMode returns the TintMode with the given name, as printed by
+// TintMode.String. The empty string selects TintBlend.
+func ParseTintMode(name string) (TintMode, error) {
+	if name == "" {
+		return TintBlend, nil
+	}
+	for m, n := range tintModeNames {
+		if n == name {
+			return m, nil
+		}
+	}
+	return 0, fmt.Errorf("mosaic: unknown tint mode %q", name)
+}
+
+func (m TintMode) String() string {
+	if name, ok := tintModeNames[m]; ok {
+		return name
+	}
+	return fmt.Sprintf("TintMode(%d)", int(m))
+}
+
+// tinted corrects an image toward a target color as it is read.
+type tinted struct {
+	image.Image
+	mode     TintMode
+	strength float64
+	// target holds the target color as RGB for TintBlend and as YCbCr
+	// for TintHue.
+	target [3]float64
+}
+
+func newTinted(img image.Image, target color.Color, mode TintMode, strength float64) image.Image {
+	if strength <= 0 {
+		return img
+	}
+	if strength > 1 {
+		strength = 1
+	}
+	t := &tinted{
+		Image:    img,
+		mode:     mode,
+		strength: strength,
+	}
+	r, g, b, _ := target.RGBA()
+	t.target = [3]float64{float64(r), float64(g), float64(b)}
+	if mode == TintHue {
+		t.target = toYCbCr(t.target)
+	}
+	return t
+}
+
+func (t *tinted) ColorModel() color.Model {
+	return color.RGBA64Model
+}
+
+// Opaque reports true, as tinted colors are always opaque.
+func (t *tinted) Opaque() bool {
+	return true
+}
+
+func (t *tinted) At(x, y int) color.Color {
+	r, g, b, _ := t.Image.At(x, y).RGBA()
+	c := [3]float64{float64(r), float64(g), float64(b)}
+
+	if t.mode == TintHue {
+		c = toYCbCr(c)
+		c[1] += (t.target[1] - c[1]) * t.strength
+		c[2] += (t.target[2] - c[2]) * t.strength
+		c = fromYCbCr(c)
+	} else {
+		for i := range c {
+			c[i] += (t.target[i] - c[i]) * t.strength
+		}
+	}
+	return sampleColor(c[0], c[1], c[2])
+}
+
+// toYCbCr converts 16-bit RGB to full range YCbCr, as in JFIF.
+func toYCbCr(c [3]float64) [3]float64 {
+	r, g, b := c[0], c[1], c[2]
+	return [3]float64{
+		0.299*r + 0.587*g + 0.114*b,
+		-0.168736*r - 0.331264*g + 0.5*b + 0x8000,
+		0.5*r - 0.418688*g - 0.081312*b + 0x8000,
+	}
+}
+
+func fromYCbCr(c [3]float64) [3]float64 {
+	y, cb, cr := c[0], c[1]-0x8000, c[2]-0x8000
+	return [3]float64{
+		y + 1.402*cr,
+		y - 0.344136*cb - 0.714136*cr,
+		y + 1.772*cb,
+	}
+}
@@ -0,0 +1,69 @@
+package mosaic
+
+import (
+	"image"
+	"image/draw"
+)
+
+// orientation maps a pixel of a w x h tile variant back to the original
+// tile.
+type orientation func(x, y, w, h int) (int, int)
+
+var (
+	rotations = []orientation{
+		func(x, y, w, h int) (int, int) { return y, w - 1 - x },         // 90°
+		func(x, y, w, h int) (int, int) { return w - 1 - x, h - 1 - y }, // 180°
+		func(x, y, w, h int) (int, int) { return h - 1 - y, x },         // 270°
+	}
+	mirrors = []orientation{
+		func(x, y, w, h int) (int, int) { return w - 1 - x, y }, // horizontal flip
+		func(x, y, w, h int) (int, int) { return x, h - 1 - y }, // vertical flip
+	}
+	// Mirrored rotations complete the eight symmetries of a square.
+	mirroredRotations = []orientation{
+		func(x, y, w, h int) (int, int) { return y, x },                 // transpose
+		func(x, y, w, h int) (int, int) { return h - 1 - y, w - 1 - x }, // anti-transpose
+	}
+)
+
+// variants returns img followed by its rotations and mirror images, as
+// selected by opts. Rotations are only made of square tiles.
+func variants(img image.Image, opts TilerOptions) []image.Image {
+	var (
+		result  = []image.Image{img}
+		size    = img.Bounds().Size()
+		square  = size.X == size.Y
+		rotated = opts.Rotations && square
+		ops     []orientation
+	)
+	if rotated {
+		ops = append(ops, rotations...)
+	}
+	if opts.Mirrors {
+		ops = append(ops, mirrors...)
+	}
+	if rotated && opts.Mirrors {
+		ops = append(ops, mirroredRotations...)
+	}
+	for _, op := range ops {
+		result = append(result, orient(img, op))
+	}
+	return result
+}
+
+func orient(img image.Image, op orientation) image.Image {
+	var (
+		bounds = img.Bounds()
+		w, h   = bounds.Dx(), bounds.Dy()
+		src    = image.NewRGBA(image.Rect(0, 0, w, h))
+		dst    = image.NewRGBA(image.Rect(0, 0, w, h))
+	)
+	draw.Draw(src, src.Bounds(), img, bounds.Min, draw.Src)
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			sx, sy := op(x, y, w, h)
+			dst.SetRGBA(x, y, src.RGBAAt(sx, sy))
+		}
+	}
+	return dst
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"image"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/Logiraptor/mosaic/mosaic"
+)
+
+// errResultTooLarge is returned for results which alone exceed the
+// store's byte limit.
+var errResultTooLarge = errors.New("the mosaic is too large to keep")
+
+// resultStore keeps finished mosaics in memory for a while so that they
+// can be served in pieces, such as deep zoom tiles. Once they take more
+// than maxBytes, the oldest are evicted early.
+type resultStore struct {
+	mu       sync.Mutex
+	ttl      time.Duration
+	maxBytes int64
+	size     int64
+	results  map[string]*result
+	// order lists the IDs of results from oldest to newest.
+	order []string
+}
+
+type result struct {
+	image   image.Image
+	pyramid *mosaic.Pyramid
+	// encoded holds the image already encoded in format, if it has been.
+	format  string
+	encoded []byte
+	expires time.Time
+}
+
+// size estimates the memory held by r: its pixels, as RGBA, and its
+// encoding.
+func (r *result) size() int64 {
+	bounds := r.image.Bounds()
+	return int64(bounds.Dx())*int64(bounds.Dy())*4 + int64(len(r.encoded))
+}
+
+func newResultStore(ttl time.Duration, maxBytes int64) *resultStore {
+	return &resultStore{
+		ttl:      ttl,
+		maxBytes: maxBytes,
+		results:  make(map[string]*result),
+	}
+}
+
+// resultTTL reads RESULT_TTL, which defaults to an hour.
+func resultTTL() time.Duration {
+	ttl, err := time.ParseDuration(os.Getenv("RESULT_TTL"))
+	if err != nil || ttl <= 0 {
+		return time.Hour
+	}
+	return ttl
+}
+
+// resultMaxBytes reads RESULT_MAX_BYTES, which defaults to 2GB.
+func resultMaxBytes() int64 {
+	maxBytes, err := strconv.ParseInt(os.Getenv("RESULT_MAX_BYTES"), 10, 64)
+	if err != nil || maxBytes <= 0 {
+		return 2 << 30
+	}
+	return maxBytes
+}
+
+// put stores img, and its encoding in format if known, and returns its
+// ID, evicting the oldest results to make room.
+func (s *resultStore) put(img image.Image, format string, encoded []byte) (string, error) {
+	r := &result{image: img, format: format, encoded: encoded}
+	if r.size() > s.maxBytes {
+		return "", errResultTooLarge
+	}
+	pyramid, err := mosaic.NewPyramid(img, mosaic.PyramidOptions{})
+	if err != nil {
+		return "", err
+	}
+	id, err := newID()
+	if err != nil {
+		return "", err
+	}
+
+	r.pyramid = pyramid
+	r.expires = time.Now().Add(s.ttl)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.expire()
+	for s.size+r.size() > s.maxBytes {
+		s.evict()
+	}
+	s.results[id] = r
+	s.order = append(s.order, id)
+	s.size += r.size()
+	return id, nil
+}
+
+func (s *resultStore) get(id string) (*result, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.expire()
+	r, ok := s.results[id]
+	return r, ok
+}
+
+// expire drops results past their TTL. As every result lives for the
+// same TTL, they expire in order. s.mu must be held.
+func (s *resultStore) expire() {
+	now := time.Now()
+	for len(s.order) > 0 && now.After(s.results[s.order[0]].expires) {
+		s.evict()
+	}
+}
+
+// evict drops the oldest result. s.mu must be held.
+func (s *resultStore) evict() {
+	id := s.order[0]
+	s.order = s.order[1:]
+	s.size -= s.results[id].size()
+	delete(s.results, id)
+}
+
+func newID() (string, error) {
+	var id [16]byte
+	if _, err := rand.Read(id[:]); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(id[:]), nil
+}
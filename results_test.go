@@ -0,0 +1,54 @@
+package main
+
+import (
+	"image"
+	"testing"
+	"time"
+)
+
+func TestResultStoreEvictsOldest(t *testing.T) {
+	// Each 10x10 result takes 400 bytes.
+	store := newResultStore(time.Hour, 1000)
+	img := image.NewRGBA(image.Rect(0, 0, 10, 10))
+	var ids []string
+	for i := 0; i < 4; i++ {
+		id, err := store.put(img, "png", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, id)
+	}
+
+	for i, id := range ids {
+		_, ok := store.get(id)
+		if want := i >= 2; ok != want {
+			t.Errorf("result %d kept: %v, want %v", i, ok, want)
+		}
+	}
+	if store.size != 800 {
+		t.Errorf("store holds %d bytes, want 800", store.size)
+	}
+}
+
+func TestResultStoreRejectsTooLarge(t *testing.T) {
+	store := newResultStore(time.Hour, 1000)
+	_, err := store.put(image.NewRGBA(image.Rect(0, 0, 10, 10)), "png", make([]byte, 601))
+	if err != errResultTooLarge {
+		t.Errorf("got error %v, want %v", err, errResultTooLarge)
+	}
+}
+
+func TestResultStoreExpires(t *testing.T) {
+	store := newResultStore(time.Millisecond, 1000)
+	id, err := store.put(image.NewRGBA(image.Rect(0, 0, 10, 10)), "png", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(5 * time.Millisecond)
+	if _, ok := store.get(id); ok {
+		t.Error("result was kept past its TTL")
+	}
+	if store.size != 0 || len(store.order) != 0 {
+		t.Errorf("expired store holds %d bytes in %d results", store.size, len(store.order))
+	}
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"image"
+	"image/png"
+	"log"
+	"time"
+
+	"github.com/Logiraptor/mosaic/mosaic"
+	"gopkg.in/go-redis/cache.v3/lrucache"
+)
+
+// tileCache keeps square tile thumbnails in a memory LRU backed by an
+// optional diskStore, so that resized tiles survive restarts.
+type tileCache struct {
+	memory   *lrucache.Cache
+	disk     *diskStore
+	fallback mosaic.ImageLoader
+}
+
+func NewTileCache(fallback mosaic.ImageLoader, disk *diskStore) *tileCache {
+	return &tileCache{
+		memory:   lrucache.New(time.Hour, memoryCacheSize),
+		disk:     disk,
+		fallback: fallback,
+	}
+}
+
+// Thumbnails returns an ImageLoader which loads images as size x size
+// squares, cropped and resized the same way as downloaded tiles.
+func (t *tileCache) Thumbnails(size int) mosaic.ImageLoader {
+	return thumbnailLoader{cache: t, size: size}
+}
+
+type thumbnailLoader struct {
+	cache *tileCache
+	size  int
+}
+
+func (l thumbnailLoader) LoadImage(ctx context.Context, url string) (image.Image, error) {
+	return l.cache.load(ctx, url, l.size)
+}
+
+func (t *tileCache) load(ctx context.Context, url string, size int) (image.Image, error) {
+	key := fmt.Sprintf("%d:%s", size, url)
+	if img, ok := t.memory.Get(key); ok {
+		return img.(image.Image), nil
+	}
+
+	if t.disk != nil {
+		if data, ok := t.disk.Get(key); ok {
+			img, err := png.Decode(bytes.NewReader(data))
+			if err == nil {
+				t.memory.Set(key, img)
+				return img, nil
+			}
+			log.Println("Corrupt tile cache entry:", url, err)
+		}
+	}
+
+	img, err := t.fallback.LoadImage(ctx, url)
+	if err != nil {
+		return nil, err
+	}
+	thumb := mosaic.Thumbnail(img, size)
+
+	if t.disk != nil {
+		var buf bytes.Buffer
+		err = png.Encode(&buf, thumb)
+		if err == nil {
+			err = t.disk.Put(key, buf.Bytes())
+		}
+		if err != nil {
+			log.Println("Tile cache write:", err)
+		}
+	}
+	t.memory.Set(key, thumb)
+	return thumb, nil
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/Logiraptor/mosaic/mosaic"
+)
+
+// A tileSourceFactory opens the tile source described by c. Local paths
+// are resolved against localRoot.
+type tileSourceFactory func(c ImageConfig, localRoot string, imageLoader mosaic.ImageLoader) (mosaic.TileSource, error)
+
+// tileSources maps ImageConfig.TileSource names to their constructors.
+var tileSources = map[string]tileSourceFactory{
+	"imgur": newImgurSource,
+	"local": newLocalSource,
+}
+
+const defaultTileSource = "imgur"
+
+func newTileSource(c ImageConfig, localRoot string, imageLoader mosaic.ImageLoader) (mosaic.TileSource, error) {
+	name := c.TileSource
+	if name == "" {
+		name = defaultTileSource
+	}
+	factory, ok := tileSources[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown tile source %q", name)
+	}
+	return factory(c, localRoot, imageLoader)
+}
+
+func newImgurSource(c ImageConfig, _ string, imageLoader mosaic.ImageLoader) (mosaic.TileSource, error) {
+	return mosaic.NewImgurSource(imageLoader, os.Getenv("IMGUR_CLIENT_ID"), c.TileSourceSubreddit), nil
+}
+
+// newLocalSource opens c.TileSourcePath inside localRoot. The local
+// source is disabled while localRoot is empty.
+func newLocalSource(c ImageConfig, localRoot string, _ mosaic.ImageLoader) (mosaic.TileSource, error) {
+	if localRoot == "" {
+		return nil, configError{{Field: "tileSource", Message: "local is not enabled on this server"}}
+	}
+	if c.TileSourcePath == "" {
+		return nil, configError{{Field: "tileSourcePath", Message: "is required for the local source"}}
+	}
+	// Cleaning the path as if it were absolute keeps it inside the root.
+	path := filepath.Join(localRoot, filepath.Clean("/"+c.TileSourcePath))
+	source, err := mosaic.OpenLocalSource(path)
+	switch {
+	case os.IsNotExist(err):
+		return nil, configError{{Field: "tileSourcePath", Message: "does not exist"}}
+	case err == mosaic.ErrNotArchive:
+		return nil, configError{{Field: "tileSourcePath", Message: "is not a directory or .zip/.tar archive"}}
+	case err != nil:
+		return nil, err
+	}
+	return source, nil
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/schema"
+)
+
+const (
+	// uploadField is the multipart form field holding an uploaded input.
+	uploadField = "InputImage"
+	// multipartMemory is how much of a multipart form is held in memory;
+	// the rest is spooled to temporary files.
+	multipartMemory = 8 << 20
+)
+
+// uploadLimits reads MAX_UPLOAD_BYTES and MAX_INPUT_PIXELS, the largest
+// request body and uploaded image accepted, which default to 20MB and
+// 50 million pixels.
+func uploadLimits() (maxBytes int64, maxPixels int) {
+	maxBytes, err := strconv.ParseInt(os.Getenv("MAX_UPLOAD_BYTES"), 10, 64)
+	if err != nil || maxBytes <= 0 {
+		maxBytes = 20 << 20
+	}
+	maxPixels, err = strconv.Atoi(os.Getenv("MAX_INPUT_PIXELS"))
+	if err != nil || maxPixels <= 0 {
+		maxPixels = 50000000
+	}
+	return maxBytes, maxPixels
+}
+
+// readRequest decodes the ImageConfig of a /generate or /jobs request
+// from its query and form values, along with any uploaded input image:
+// either a file in the InputImage field of a multipart form, or the whole
+// body of a request whose Content-Type is an image type. The upload is
+// returned still encoded, once readInput has accepted it, or nil if
+// there was none; it takes the place of any InputImageURL.
+func (m *MosaicGenerator) readRequest(rw http.ResponseWriter, req *http.Request) (ImageConfig, []byte, error) {
+	var (
+		config ImageConfig
+		upload []byte
+		err    error
+	)
+	if m.MaxUploadBytes > 0 {
+		req.Body = http.MaxBytesReader(rw, req.Body, m.MaxUploadBytes)
+	}
+	mediaType, _, _ := mime.ParseMediaType(req.Header.Get("Content-Type"))
+	switch {
+	case mediaType == "multipart/form-data":
+		err = req.ParseMultipartForm(multipartMemory)
+		if err != nil {
+			return config, nil, err
+		}
+		defer req.MultipartForm.RemoveAll()
+		file, _, err := req.FormFile(uploadField)
+		switch err {
+		case nil:
+			defer file.Close()
+			upload, err = m.readInput(file)
+			if err != nil {
+				return config, nil, err
+			}
+		case http.ErrMissingFile:
+		default:
+			return config, nil, err
+		}
+		// Browsers send an empty file input as a value, not a file.
+		delete(req.Form, uploadField)
+	case strings.HasPrefix(mediaType, "image/"):
+		upload, err = m.readInput(req.Body)
+		if err != nil {
+			return config, nil, err
+		}
+		req.ParseForm()
+	default:
+		req.ParseForm()
+	}
+
+	err = schema.NewDecoder().Decode(&config, req.Form)
+	if upload != nil {
+		// The upload replaces any URL, such as the form's default, which
+		// would otherwise still be validated.
+		config.InputImageURL = ""
+	}
+	return config, upload, err
+}
+
+// readInput reads an uploaded input image and checks its size, without
+// decoding it.
+func (m *MosaicGenerator) readInput(r io.Reader) ([]byte, error) {
+	upload, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	size, _, err := image.DecodeConfig(bytes.NewReader(upload))
+	if err != nil {
+		return nil, configError{{Field: "inputImage", Message: "is not a PNG, JPEG or GIF image"}}
+	}
+	if m.MaxInputPixels > 0 && size.Width*size.Height > m.MaxInputPixels {
+		var errs configError
+		errs.add("inputImage", "is %dx%d, more than %d pixels", size.Width, size.Height, m.MaxInputPixels)
+		return nil, errs
+	}
+	return upload, nil
+}
+
+// decodeInput decodes an upload accepted by readInput.
+func decodeInput(upload []byte) (image.Image, error) {
+	img, _, err := image.Decode(bytes.NewReader(upload))
+	if err != nil {
+		return nil, configError{{Field: "inputImage", Message: "could not be decoded: " + err.Error()}}
+	}
+	return img, nil
+}
+
+// tooLarge reports whether err is from reading past MaxUploadBytes.
+func tooLarge(err error) bool {
+	var maxBytes *http.MaxBytesError
+	return errors.As(err, &maxBytes)
+}
+
+// requestStatus returns the status with which to reject a request that
+// readRequest could not decode.
+func requestStatus(err error) int {
+	if tooLarge(err) {
+		return http.StatusRequestEntityTooLarge
+	}
+	return http.StatusBadRequest
+}
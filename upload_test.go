@@ -0,0 +1,58 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	"image/png"
+	"mime/multipart"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestUploadReplacesInputImageURL(t *testing.T) {
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	// The form's default URL is relative when APP_URL is unset.
+	form.WriteField("InputImageURL", "/static/cat.jpg")
+	form.WriteField("TileSize", "10")
+	form.WriteField("TileSourceSubreddit", "pics")
+	file, err := form.CreateFormFile(uploadField, "in.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := png.Encode(file, image.NewGray(image.Rect(0, 0, 20, 10))); err != nil {
+		t.Fatal(err)
+	}
+	form.Close()
+
+	req := httptest.NewRequest("POST", "/generate", &body)
+	req.Header.Set("Content-Type", form.FormDataContentType())
+	m := &MosaicGenerator{MaxUploadBytes: 1 << 20, MaxInputPixels: 1000}
+	config, upload, err := m.readRequest(httptest.NewRecorder(), req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if upload == nil {
+		t.Fatal("upload was not read")
+	}
+	if config.InputImageURL != "" || config.TileSize != 10 {
+		t.Errorf("got config %+v, want TileSize 10 and no InputImageURL", config)
+	}
+	if _, err := config.normalize(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestUploadTooManyPixels(t *testing.T) {
+	var body bytes.Buffer
+	if err := png.Encode(&body, image.NewGray(image.Rect(0, 0, 40, 30))); err != nil {
+		t.Fatal(err)
+	}
+	req := httptest.NewRequest("POST", "/generate", &body)
+	req.Header.Set("Content-Type", "image/png")
+	m := &MosaicGenerator{MaxUploadBytes: 1 << 20, MaxInputPixels: 1000}
+	_, _, err := m.readRequest(httptest.NewRecorder(), req)
+	if fields, ok := err.(configError); !ok || fields[0].Field != "inputImage" {
+		t.Errorf("got error %v, want one for inputImage", err)
+	}
+}
@@ -0,0 +1,21 @@
+#!/bin/sh
+# Vendors the OpenSeadragon build used by the deep zoom viewer into
+# public/openseadragon, from where it is served under /static/.
+set -e
+
+version=4.1.0
+dir=public/openseadragon
+tmp=$(mktemp -d)
+trap 'rm -rf "$tmp"' EXIT
+
+curl -sSfL "https://registry.npmjs.org/openseadragon/-/openseadragon-$version.tgz" | tar -xz -C "$tmp"
+build="$tmp/package/build/openseadragon"
+
+rm -rf "$dir"
+mkdir -p "$dir"
+cp "$build/openseadragon.min.js" "$dir/"
+cp -R "$build/images" "$dir/"
+if [ -f "$tmp/package/LICENSE.txt" ]; then
+	cp "$tmp/package/LICENSE.txt" "$dir/"
+fi
+echo "$version" > "$dir/VERSION"
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"archive/zip"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/Logiraptor/mosaic/mosaic"
+)
+
+// zoomName is the name of the files making up each result's pyramid.
+const zoomName = "mosaic"
+
+// openSeadragonCDN hosts the OpenSeadragon build used by the viewer when
+// none has been vendored into public/openseadragon.
+const openSeadragonCDN = "https://cdn.jsdelivr.net/npm/openseadragon@4.1.0/build/openseadragon/"
+
+// openSeadragonBase returns the URL the viewer loads OpenSeadragon from:
+// /static/openseadragon/ once vendor_openseadragon.sh has been run, and
+// otherwise the CDN.
+func openSeadragonBase() string {
+	if _, err := os.Stat("public/openseadragon/openseadragon.min.js"); err == nil {
+		return "/static/openseadragon/"
+	}
+	return openSeadragonCDN
+}
+
+// zoomHandler serves stored results under /zoom/{id}/: a deep zoom
+// viewer, the pyramid's descriptor and tiles, rendered on demand, and
+// downloads of the full image or pyramid.
+type zoomHandler struct {
+	results *resultStore
+	// viewer is the base URL of OpenSeadragon's script and images.
+	viewer string
+}
+
+func (z *zoomHandler) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+	parts := strings.SplitN(strings.TrimPrefix(req.URL.Path, "/zoom/"), "/", 2)
+	res, ok := z.results.get(parts[0])
+	if !ok {
+		http.NotFound(rw, req)
+		return
+	}
+	if len(parts) == 1 {
+		http.Redirect(rw, req, req.URL.Path+"/", http.StatusMovedPermanently)
+		return
+	}
+	maxAge := int(res.expires.Sub(time.Now()).Seconds())
+	rw.Header().Set("Cache-Control", fmt.Sprintf("private, max-age=%d", maxAge))
+
+	var err error
+	switch path := parts[1]; path {
+	case "":
+		err = tmpls.ExecuteTemplate(rw, "zoom.html", map[string]interface{}{
+			"Name":   zoomName,
+			"Viewer": z.viewer,
+		})
+	case zoomName + ".dzi":
+		rw.Header().Set("Content-Type", "application/xml")
+		err = res.pyramid.WriteDescriptor(rw)
+	case zoomName + ".png", zoomName + ".jpg":
+		format := strings.TrimPrefix(path, zoomName+".")
+		rw.Header().Set("Content-Type", contentType(format))
+		if res.encoded != nil && res.format == format {
+			_, err = rw.Write(res.encoded)
+		} else {
+			err = encodeImage(rw, res.image, format)
+		}
+	case zoomName + ".zip":
+		rw.Header().Set("Content-Type", "application/zip")
+		rw.Header().Set("Content-Disposition", "attachment; filename="+zoomName+".zip")
+		archive := zip.NewWriter(rw)
+		err = res.pyramid.Write(archive, zoomName)
+		if err == nil {
+			err = archive.Close()
+		}
+	default:
+		var level, col, row int
+		_, err = fmt.Sscanf(path, zoomName+"_files/%d/%d_%d.png", &level, &col, &row)
+		if err != nil || res.pyramid.TilePath(zoomName, level, col, row) != path {
+			http.NotFound(rw, req)
+			return
+		}
+		rw.Header().Set("Content-Type", "image/png")
+		err = res.pyramid.EncodeTile(rw, level, col, row)
+		if err == mosaic.ErrNoTile {
+			http.NotFound(rw, req)
+			return
+		}
+	}
+	if err != nil {
+		log.Println("Serving", req.URL.Path+":", err)
+	}
+}